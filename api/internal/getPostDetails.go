@@ -18,21 +18,20 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package internal
 
 import (
-	"bitwise7/vxinst/flags"
+	"bitwise7/vxinst/scraper"
+	"bitwise7/vxinst/shortcode"
+	"bitwise7/vxinst/store"
 	"bitwise7/vxinst/utils"
-	"fmt"
-	"log/slog"
+	"errors"
 	"net/http"
-	"time"
 
-	"github.com/getsentry/sentry-go"
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
-// Returns the details of a post
+// Returns the details of a post. The ID can be a shortcode, a numeric media ID
+// or any instagram post URL
 // Example request would be: GET /api/getPostDetails?id=<postId>
-func GetPostDetails(c *gin.Context, db *gorm.DB) {
+func GetPostDetails(c *gin.Context, posts *store.Store) {
 	postId := c.Query("id")
 
 	if postId == "" {
@@ -42,80 +41,59 @@ func GetPostDetails(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
-	var data *utils.HtmlData
-	create := false
-
-	err := db.
-		Model(&utils.HtmlData{}).
-		Where("shortcode = ?", postId).
-		First(&data).
-		Error
-	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			slog.Debug("[internal] Post record not found in database. Fetching new data")
-		} else {
-			slog.Error("[internal] Failed to retrieve post data from database", slog.Any("err", err))
+	if code, _, ok := utils.ParsePostURL(postId); ok {
+		postId = code
+	} else if kind, shareId, ok := utils.ParseShareURL(postId); ok {
+		code, err := posts.ResolveShare(c.Request.Context(), kind, shareId)
+		if errors.Is(err, utils.ErrNotAPost) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Share link doesn't point at a post",
+			})
+			return
 		}
 
-		create = true
-
-		data, err = utils.ScrapeFromHTML(postId)
-		fmt.Println(data)
 		if err != nil {
-			slog.Error("Failed to scrape from HTML", slog.Any("err", err))
-			sentry.CaptureException(err)
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to scrape post data",
+				"error": "Failed to resolve share link",
 			})
 			return
-		} else if data == nil {
-			slog.Debug("No data returned from scraping. Trying to fetch from API")
-			igResp, err := utils.FetchPost(postId)
-			if err != nil && err.Error()[0:8] != "bad flag" {
-				slog.Error("Failed to fetch data from API", slog.Any("err", err))
-				sentry.CaptureException(err)
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": "Failed to fetch post data",
-				})
-				return
-			} else if igResp != nil && len(igResp.Items) > 0 && len(igResp.Items[0].VideoVersions) > 0 && len(igResp.Items[0].ImageVersions.Candidates) > 0 {
-				data = &utils.HtmlData{
-					// TODO: fix this not giving enough data
-					Video: &utils.VideoData{
-						URL: data.Video.URL,
-					},
-					ThumbnailURL: igResp.Items[0].ImageVersions.Candidates[0].URL,
-				}
-			}
 		}
+
+		postId = code
 	}
 
-	if data != nil {
-		c.JSON(http.StatusOK, data)
-	} else {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "No data found for post. The post may be private or instagram may be blocking us",
+	postId, ok := shortcode.Parse(postId)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid post id provided",
 		})
+		return
 	}
 
-	if create {
-		newRecord := &utils.HtmlData{
-			Shortcode: postId,
-			ExpiresAt: time.Now().Add(time.Hour * time.Duration(24*(*flags.MemoryLifetime))).Unix(),
-		}
-
-		if data != nil {
-			newRecord = data
-			newRecord.ExpiresAt = time.Now().Add(time.Hour * time.Duration(24*(*flags.MemoryLifetime))).Unix()
+	data, err := posts.Get(c.Request.Context(), postId)
+	if err != nil {
+		if !errors.Is(err, scraper.ErrNoData) {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to scrape post data",
+			})
+			return
 		}
 
-		if err := db.Model(&utils.HtmlData{}).Create(newRecord).Error; err != nil {
-			sentry.CaptureException(err)
-			slog.Error("[internal] Failed to save record to memory database", slog.Any("err", err))
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to save post data",
+		var failure *store.CachedFailure
+		if errors.As(err, &failure) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":    "Post recently failed to scrape. The post may be private or instagram may be blocking us",
+				"reason":   failure.Reason,
+				"retry_at": failure.ExpiresAt,
 			})
 			return
 		}
+
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "No data found for post. The post may be private or instagram may be blocking us",
+		})
+		return
 	}
+
+	c.JSON(http.StatusOK, data)
 }
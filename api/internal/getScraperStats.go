@@ -0,0 +1,55 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package internal
+
+import (
+	"bitwise7/vxinst/scraper"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type scraperStats struct {
+	Name         string `json:"name"`
+	Capabilities string `json:"capabilities"`
+	// Times the method was the one to find a post
+	Wins int `json:"wins"`
+}
+
+// Returns the enabled scraping methods in the order they're tried along with
+// how often each of them found a post, to help with ordering --scraping-methods.
+// Counts start over when the server restarts
+// Example request would be: GET /api/scraperStats
+func GetScraperStats(c *gin.Context, scrapers *scraper.Registry) {
+	wins := scrapers.Wins()
+	enabled := scrapers.Enabled()
+
+	methods := make([]scraperStats, 0, len(enabled))
+	for _, s := range enabled {
+		methods = append(methods, scraperStats{
+			Name:         s.Name(),
+			Capabilities: s.Capabilities().String(),
+			Wins:         wins[s.Name()],
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"race":    scrapers.Race(),
+		"methods": methods,
+	})
+}
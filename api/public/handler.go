@@ -20,41 +20,83 @@ package public
 import (
 	"bitwise7/vxinst/api/internal"
 	"bitwise7/vxinst/flags"
+	"bitwise7/vxinst/mediacache"
 	"bitwise7/vxinst/middleware"
+	"bitwise7/vxinst/store"
+	"bitwise7/vxinst/utils"
+	"log/slog"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	cache "github.com/chenyahui/gin-cache"
 	"github.com/chenyahui/gin-cache/persist"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
 	"gorm.io/gorm"
 )
 
 type Handler struct {
 	Db     *gorm.DB
 	Router *gin.Engine
+	Store  *store.Store
+	// Nil if redis is disabled
+	Redis *redis.Client
+	// Nil if media caching is disabled
+	MediaCache *mediacache.Cache
+
+	mosaicGroup singleflight.Group
 }
 
 // Attaches middleware and sets endpoint funcs
-func NewHandler(db *gorm.DB) *Handler {
+func NewHandler(db *gorm.DB, store *store.Store, mediaCache *mediacache.Cache) *Handler {
 	r := gin.New()
 
+	// Trust nobody unless told otherwise, so clients can't pick their own IP
+	// to get around rate limits
+	if err := r.SetTrustedProxies(*flags.TrustedProxies); err != nil {
+		slog.Error("Invalid trusted proxy", slog.Any("err", err))
+		os.Exit(1)
+	}
+	r.RemoteIPHeaders = []string{"CF-Connecting-IP", "X-Forwarded-For", "X-Real-IP"}
+
+	var rdb *redis.Client
+	var limiter middleware.Limiter = middleware.NewRateLimiter(*flags.RateLimit, *flags.RateBurst)
+
+	// Share rate limits between every instance using the same redis
+	if *flags.RedisEnable {
+		rdb = redis.NewClient(&redis.Options{
+			Addr:     *flags.RedisAddr,
+			Password: *flags.RedisPasswd,
+			DB:       *flags.RedisDB,
+		})
+
+		limiter = middleware.NewRedisLimiter(rdb, *flags.RateLimit, *flags.RateBurst)
+	}
+
 	r.Use(
 		gin.Recovery(),
 		gin.ErrorLogger(),
-		middleware.RateLimiterMiddleware(middleware.NewRateLimiter(5, 10)),
+		middleware.RateLimiterMiddleware(limiter, *flags.RateLimitAllow),
 		middleware.CorsMiddleware(),
 		// sentrygin.New(sentrygin.Options{
 
 		// }),
 	)
 
+	// Has to be set before the templates are loaded
+	r.SetFuncMap(templateFuncs)
 	r.LoadHTMLGlob("templates/*")
 
 	return &Handler{
-		Db:     db,
-		Router: r,
+		Db:         db,
+		Router:     r,
+		Redis:      rdb,
+		Store:      store,
+		MediaCache: mediaCache,
 	}
 }
 
@@ -62,30 +104,99 @@ func (h *Handler) Init() {
 	var st persist.CacheStore = persist.NewMemoryStore(time.Minute * 1)
 	cacheExpire := time.Minute * time.Duration(*flags.CacheLifetime)
 
-	if *flags.RedisEnable {
-		rdb := redis.NewClient(&redis.Options{
-			Addr:     *flags.RedisAddr,
-			Password: *flags.RedisPasswd,
-			DB:       *flags.RedisDB,
-		})
-
-		st = persist.NewRedisStore(rdb)
+	if h.Redis != nil {
+		st = persist.NewRedisStore(h.Redis)
 	}
 
 	// Cache is only enabled if we're not in debug mode
 	if !*flags.GinLogs {
-		h.Router.Use(cache.CacheByRequestURI(st, cacheExpire))
+		h.Router.Use(cache.Cache(st, cacheExpire,
+			cache.WithCacheStrategyByRequest(cacheStrategy),
+			// Cached responses would otherwise replay someone else's rate limit
+			cache.WithDiscardHeaders([]string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"}),
+		))
 	}
 
+	h.routes()
+}
+
+// Registers every endpoint. Kept apart from [Handler.Init] so the routing can be
+// tested without the cache middleware
+func (h *Handler) routes() {
 	h.Router.GET("/reel/:id", h.ServeVideo)
 	h.Router.GET("/reels/:id", h.ServeVideo)
 	h.Router.GET("/p/:id", h.ServeVideo)
+	h.Router.GET("/reel/:id/:index", h.ServeVideo)
+	h.Router.GET("/reels/:id/:index", h.ServeVideo)
+	// Would otherwise match /reels/:id/:index with "videos" as the post ID
+	h.Router.GET("/reels/videos/:id", h.ServeVideo)
+	h.Router.GET("/reels/videos/:id/:index", h.ServeVideo)
+	h.Router.GET("/p/:id/:index", h.ServeVideo)
+	h.Router.GET("/tv/:id", h.ServeVideo)
 	h.Router.GET("/favicon.ico", func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
 	h.Router.GET("/", func(ctx *gin.Context) {
 		ctx.HTML(http.StatusOK, "main.html", gin.H{
 			"demo": "/assets/demo.png",
 		})
 	})
-	h.Router.GET("/share/:id", h.FollowShare)
-	h.Router.GET("/api/getPostDetails", func(c *gin.Context) { internal.GetPostDetails(c, h.Db) })
+	// gin doesn't allow differently named wildcards in the same place, so for
+	// the old /share/<id> links the ID ends up in :kind
+	h.Router.GET("/share/:kind", h.FollowShare)
+	h.Router.GET("/share/:kind/:id", h.FollowShare)
+	h.Router.GET("/mosaic/:id", h.ServeMosaic)
+	h.Router.GET("/oembed", h.ServeOEmbed)
+	h.Router.GET("/media/:shortcode/:index", h.ServeMedia)
+	h.Router.HEAD("/media/:shortcode/:index", h.ServeMedia)
+	h.Router.GET("/api/getPostDetails", func(c *gin.Context) { internal.GetPostDetails(c, h.Store) })
+	// Debug endpoint, only available when a token to access it is set
+	if *flags.StatsToken != "" {
+		h.Router.GET("/api/scraperStats", middleware.TokenMiddleware(*flags.StatsToken), func(c *gin.Context) { internal.GetScraperStats(c, h.Store.Scrapers()) })
+	}
+	h.Router.GET("/d/*path", h.ServeAnyPost)
+	h.Router.GET("/g/*path", h.ServeAnyPost)
+	h.Router.GET("/t/*path", h.ServeAnyPost)
+	h.Router.NoRoute(h.ServeAnyPost)
+}
+
+// Decides which responses are cached and under what key
+func cacheStrategy(c *gin.Context) (bool, cache.Strategy) {
+	// Proxied media is streamed and may be requested in ranges, so it can't be
+	// buffered into the cache
+	if strings.HasPrefix(c.Request.URL.Path, "/media/") {
+		return false, cache.Strategy{}
+	}
+
+	// Stats have to be current to be of any use
+	if c.Request.URL.Path == "/api/scraperStats" {
+		return false, cache.Strategy{}
+	}
+
+	// Direct links only ever redirect, and the redirects point at short lived URLs
+	mode, _ := requestMode(c)
+	if mode == modeDirect {
+		return false, cache.Strategy{}
+	}
+
+	// Browsers and bots get different responses for the same URL
+	class := "human:"
+	if wantsEmbed(c) {
+		class = "bot:"
+	}
+
+	// Captions are cut to a different length depending on the client
+	if mode == modeText {
+		class += strconv.Itoa(descriptionLimit(c)) + ":"
+	}
+
+	// Links back to us are built from the Host header unless a public URL is
+	// set, so one client can't get its host into everyone else's embeds
+	if *flags.PublicURL == "" {
+		class += requestOrigin(c) + ":"
+	}
+
+	// The mode can come from the host and the language from a header, neither
+	// of which are part of the request URI
+	return true, cache.Strategy{
+		CacheKey: class + strconv.Itoa(int(mode)) + ":" + requestLocale(c).Lang + ":" + utils.StripTrackingParams(c.Request.RequestURI),
+	}
 }
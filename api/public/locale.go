@@ -0,0 +1,130 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package public
+
+import (
+	"bitwise7/vxinst/flags"
+	"html/template"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+)
+
+// Words and number formatting used in embeds
+type locale struct {
+	Lang string
+	// Title of embeds, gets the username appended
+	PostBy string
+	// Decimal separator
+	Decimal string
+	// Suffixes for thousands, millions and billions
+	Units [3]string
+}
+
+// The first locale is used when nothing else matches
+var locales = []locale{
+	{Lang: "en", PostBy: "Post by @", Decimal: ".", Units: [3]string{"K", "M", "B"}},
+	{Lang: "de", PostBy: "Beitrag von @", Decimal: ",", Units: [3]string{" Tsd.", " Mio.", " Mrd."}},
+	{Lang: "es", PostBy: "Publicación de @", Decimal: ",", Units: [3]string{" mil", " M", " mil M"}},
+	{Lang: "fr", PostBy: "Publication de @", Decimal: ",", Units: [3]string{" k", " M", " Md"}},
+	{Lang: "pl", PostBy: "Post od @", Decimal: ",", Units: [3]string{" tys.", " mln", " mld"}},
+	{Lang: "pt", PostBy: "Publicação de @", Decimal: ",", Units: [3]string{" mil", " mi", " bi"}},
+	{Lang: "ru", PostBy: "Публикация @", Decimal: ",", Units: [3]string{" тыс.", " млн", " млрд"}},
+}
+
+var localeMatcher = func() language.Matcher {
+	tags := make([]language.Tag, len(locales))
+	for i, loc := range locales {
+		tags[i] = language.MustParse(loc.Lang)
+	}
+
+	return language.NewMatcher(tags)
+}()
+
+// Picks the locale from the ?lang= query, falling back to the Accept-Language header
+func requestLocale(c *gin.Context) locale {
+	_, index := language.MatchStrings(localeMatcher, c.Query("lang"), c.GetHeader("Accept-Language"))
+	return locales[index]
+}
+
+// Functions available to every template
+var templateFuncs = template.FuncMap{
+	"stats": stats,
+}
+
+// A single count shown in the embed description
+type stat struct {
+	Emoji string
+	Value string
+}
+
+// Returns the counts to show in the description, formatted for the locale of the embed
+func stats(data *HtmlOpenGraphData) []stat {
+	all := []struct {
+		emoji string
+		count int
+	}{
+		{"❤️", data.Likes},
+		{"💬", data.Comments},
+		{"👁️", data.Views},
+	}
+
+	shown := make([]stat, 0, len(all))
+
+	for _, s := range all {
+		// Instagram returns -1 for counts the author hid. Zero usually means hidden too
+		if s.count < 0 || (s.count == 0 && *flags.HideEmptyStats) {
+			continue
+		}
+
+		shown = append(shown, stat{Emoji: s.emoji, Value: compactNumber(s.count, data.Locale)})
+	}
+
+	return shown
+}
+
+// Formats numbers like 1843291 as 1.8M. Only numbers under 100 units get a
+// decimal digit, and it's dropped if it's zero
+func compactNumber(n int, loc locale) string {
+	if n < 1000 {
+		return strconv.Itoa(n)
+	}
+
+	value := float64(n)
+	unit := -1
+
+	for value >= 1000 && unit < len(loc.Units)-1 {
+		value /= 1000
+		unit++
+	}
+
+	// Round down so 999999 doesn't turn into 1000K
+	var formatted string
+	if value < 100 {
+		formatted = strconv.FormatFloat(math.Floor(value*10)/10, 'f', 1, 64)
+		formatted = strings.TrimSuffix(formatted, ".0")
+		formatted = strings.Replace(formatted, ".", loc.Decimal, 1)
+	} else {
+		formatted = strconv.FormatFloat(math.Floor(value), 'f', 0, 64)
+	}
+
+	return formatted + loc.Units[unit]
+}
@@ -0,0 +1,206 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package public
+
+import (
+	"bitwise7/vxinst/flags"
+	"bitwise7/vxinst/mediacache"
+	"bitwise7/vxinst/shortcode"
+	"bitwise7/vxinst/utils"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// No overall timeout since streaming a long video can take a while. The
+	// request context cancels the upstream request if the client goes away
+	mediaClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout: 5 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 10 * time.Second,
+			MaxIdleConnsPerHost:   16,
+		},
+	}
+
+	// Headers passed from the client to the CDN
+	mediaRequestHeaders = []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"}
+	// Headers passed from the CDN back to the client
+	mediaResponseHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified", "Cache-Control"}
+	// Hosts we're willing to fetch media from
+	mediaHosts = []string{".cdninstagram.com", ".fbcdn.net"}
+)
+
+// Streams the video (or image if the slide has no video) of a post slide
+// through us. Useful when the CDN blocks chat clients or their region. Links
+// are signed by [mediaURL] so we don't proxy posts nobody embedded through us.
+// Example request would be: GET /media/<shortcode>/<1-based slide index>?exp=<unix>&sig=<signature>[&thumbnail=1]
+func (h *Handler) ServeMedia(c *gin.Context) {
+	postId := c.Param("shortcode")
+	if !shortcode.Valid(postId) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 1 {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	expires, err := strconv.ParseInt(c.Query("exp"), 10, 64)
+	if err != nil || !utils.VerifyMediaLink(postId, index, c.Query("thumbnail") != "", expires, c.Query("sig")) {
+		slog.Debug("Invalid or expired media link", slog.String("id", postId))
+		c.Status(http.StatusForbidden)
+		return
+	}
+
+	data, err := h.Store.Get(c.Request.Context(), postId)
+	if err != nil || index > max(len(data.Media), 1) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	slide := data.Slide(index)
+
+	thumbnail := slide.Video == nil || c.Query("thumbnail") != ""
+
+	target := slide.ThumbnailURL
+	if !thumbnail {
+		target = slide.Video.URL
+	}
+
+	if h.MediaCache != nil {
+		key := mediacache.Key(data.Shortcode, index, thumbnail)
+
+		if f, info, ok := h.MediaCache.Open(key); ok {
+			defer f.Close()
+
+			slog.Debug("Serving media from disk", slog.String("key", key))
+
+			// ServeContent handles Range and conditional requests by itself
+			c.Header("ETag", strconv.Quote(key+"-"+strconv.FormatInt(info.ModTime().Unix(), 36)))
+			http.ServeContent(c.Writer, c.Request, "", info.ModTime(), f)
+			return
+		}
+
+		h.MediaCache.Warm(key, target)
+	}
+
+	streamMedia(c, target)
+}
+
+// Copies the response of the CDN to the client, passing through the headers
+// needed for seeking (Range) and client side caching (ETag)
+func streamMedia(c *gin.Context, target string) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "https" || !isMediaHost(u.Hostname()) {
+		slog.Debug("Refusing to proxy media", slog.String("url", target))
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), "GET", target, nil)
+	if err != nil {
+		slog.Error("Failed to prepare media request", slog.Any("err", err))
+		c.Status(http.StatusBadGateway)
+		return
+	}
+
+	req.Header.Set("User-Agent", *flags.InstagramBrowserAgent)
+	for _, header := range mediaRequestHeaders {
+		if value := c.GetHeader(header); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+
+	res, err := mediaClient.Do(req)
+	if err != nil {
+		slog.Error("Failed to fetch media", slog.Any("err", err))
+		c.Status(http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusNotModified, http.StatusRequestedRangeNotSatisfiable:
+	default:
+		slog.Debug("CDN refused media request", slog.Int("status", res.StatusCode), slog.String("url", target))
+		c.Status(http.StatusBadGateway)
+		return
+	}
+
+	for _, header := range mediaResponseHeaders {
+		if value := res.Header.Get(header); value != "" {
+			c.Header(header, value)
+		}
+	}
+
+	c.Status(res.StatusCode)
+
+	if c.Request.Method == http.MethodHead || res.StatusCode == http.StatusNotModified {
+		return
+	}
+
+	if _, err := io.Copy(c.Writer, res.Body); err != nil {
+		// Almost always the client going away mid stream
+		slog.Debug("Media stream interrupted", slog.Any("err", err))
+	}
+}
+
+func isMediaHost(host string) bool {
+	for _, suffix := range mediaHosts {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the URL embeds should use for the media of a slide. Points at
+// [Handler.ServeMedia] if media is proxied or cached, otherwise at the CDN
+func mediaURL(c *gin.Context, data *utils.HtmlData, index int, cdnURL string, thumbnail bool) string {
+	if !*flags.ProxyMedia && *flags.MediaCacheDir == "" {
+		return cdnURL
+	}
+
+	index = max(index, 1)
+	expires, signature := utils.SignMediaLink(data.Shortcode, index, thumbnail)
+
+	query := url.Values{}
+	query.Set("exp", strconv.FormatInt(expires, 10))
+	query.Set("sig", signature)
+
+	if thumbnail {
+		query.Set("thumbnail", "1")
+	}
+
+	return requestOrigin(c) + "/media/" + data.Shortcode + "/" + strconv.Itoa(index) + "?" + query.Encode()
+}
@@ -0,0 +1,68 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package public
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// What kind of response a post link gets
+type embedMode int
+
+const (
+	// Regular embed with the media, author and stats
+	modeEmbed embedMode = iota
+	// Redirect straight to the media itself
+	modeDirect
+	// Just the media, without the title and stats
+	modeGallery
+	// The caption without any media
+	modeText
+)
+
+// Modes can be picked either with a path prefix (/d/reel/<postId>) or with a
+// subdomain (d.example.com/reel/<postId>). Text mode can also be picked with ?text=1
+var modePrefixes = map[string]embedMode{
+	"d": modeDirect,
+	"g": modeGallery,
+	"t": modeText,
+}
+
+// Returns the mode requested and the request path without the mode prefix
+func requestMode(c *gin.Context) (embedMode, string) {
+	path := c.Request.URL.Path
+
+	prefix, rest, found := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if mode, ok := modePrefixes[prefix]; ok && found {
+		return mode, "/" + rest
+	}
+
+	sub, _, found := strings.Cut(c.Request.Host, ".")
+	if mode, ok := modePrefixes[sub]; ok && found {
+		return mode, path
+	}
+
+	if text, _ := strconv.ParseBool(c.Query("text")); text {
+		return modeText, path
+	}
+
+	return modeEmbed, path
+}
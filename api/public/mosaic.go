@@ -0,0 +1,77 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package public
+
+import (
+	"bitwise7/vxinst/flags"
+	"bitwise7/vxinst/shortcode"
+	"bitwise7/vxinst/utils"
+	"log/slog"
+	"net/http"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gin-gonic/gin"
+)
+
+// Serves a single image composed of up to 4 carousel images. Discord can only
+// show one og:image so this is the only way to show more than one slide at once.
+// The post is usually already stored since the embed pointing here is rendered
+// by ProcessPost first
+func (h *Handler) ServeMosaic(c *gin.Context) {
+	postId, ok := shortcode.Parse(c.Param("id"))
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	// Goes through the store so records with expired media links get refreshed
+	data, err := h.Store.Get(c.Request.Context(), postId)
+	if err != nil {
+		slog.Debug("No usable record found for mosaic", slog.String("id", postId), slog.Any("err", err))
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	// Anyone can request a mosaic for any post, so this isn't worth reporting
+	if len(utils.MosaicImages(data)) < 2 {
+		slog.Debug("Not enough images for mosaic", slog.String("id", postId))
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	// Viral carousels get requested by a lot of clients at once. Only download
+	// and compose the images once
+	res, err, _ := h.mosaicGroup.Do(postId, func() (any, error) {
+		return utils.ComposeMosaic(*flags.MosaicDir, data)
+	})
+	if err != nil {
+		slog.Error("Failed to compose mosaic", slog.String("id", postId), slog.Any("err", err))
+		sentry.CaptureException(err)
+
+		// Showing the first slide is better than showing nothing
+		if slide := data.Slide(1); slide != nil && slide.ThumbnailURL != "" {
+			c.Redirect(http.StatusFound, slide.ThumbnailURL)
+			return
+		}
+
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.File(res.(string))
+}
@@ -0,0 +1,159 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package public
+
+import (
+	"bitwise7/vxinst/shortcode"
+	"bitwise7/vxinst/utils"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// See https://oembed.com/#section2.3
+type OEmbedResponse struct {
+	Version         string `json:"version"`
+	Type            string `json:"type"`
+	Title           string `json:"title,omitempty"`
+	AuthorName      string `json:"author_name,omitempty"`
+	AuthorURL       string `json:"author_url,omitempty"`
+	ProviderName    string `json:"provider_name"`
+	ProviderURL     string `json:"provider_url"`
+	ThumbnailURL    string `json:"thumbnail_url,omitempty"`
+	ThumbnailWidth  int    `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight int    `json:"thumbnail_height,omitempty"`
+	URL             string `json:"url,omitempty"`
+	HTML            string `json:"html,omitempty"`
+	Width           int    `json:"width,omitempty"`
+	Height          int    `json:"height,omitempty"`
+}
+
+// oEmbed provider endpoint. Some consumers (like the author line in Discord
+// embeds) only read oEmbed instead of Open Graph tags.
+// Example request would be: GET /oembed?url=https://www.instagram.com/p/<postId>/
+func (h *Handler) ServeOEmbed(c *gin.Context) {
+	if format := c.Query("format"); format != "" && format != "json" {
+		c.Status(http.StatusNotImplemented)
+		return
+	}
+
+	postId, slideIdx, ok := utils.ParsePostURL(c.Query("url"))
+
+	if kind, shareId, isShare := utils.ParseShareURL(c.Query("url")); isShare {
+		var err error
+		postId, err = h.Store.ResolveShare(c.Request.Context(), kind, shareId)
+		ok = err == nil
+	}
+
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Unsupported URL",
+		})
+		return
+	}
+
+	// Media IDs are accepted too, and anything that isn't a valid ID shouldn't be scraped
+	postId, ok = shortcode.Parse(postId)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Invalid post id provided",
+		})
+		return
+	}
+
+	data, err := h.Store.Get(c.Request.Context(), postId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "No data found for post",
+		})
+		return
+	}
+
+	slide := data.Slide(slideIdx)
+	shownIdx := slideIdx
+	if shownIdx < 1 || shownIdx > len(data.Media) {
+		shownIdx = 1
+	}
+
+	res := &OEmbedResponse{
+		Version:         "1.0",
+		Type:            "link",
+		Title:           requestLocale(c).PostBy + data.Author.Username,
+		AuthorName:      "@" + data.Author.Username,
+		AuthorURL:       data.Author.ProfileURL,
+		ProviderName:    "VxInst",
+		ProviderURL:     requestOrigin(c),
+		ThumbnailURL:    mediaURL(c, data, shownIdx, slide.ThumbnailURL, true),
+		ThumbnailWidth:  slide.Width,
+		ThumbnailHeight: slide.Height,
+	}
+
+	width, height := fitOEmbed(c, slide.Width, slide.Height)
+
+	if slide.Video != nil {
+		res.Type = "video"
+		res.Width, res.Height = width, height
+		res.HTML = `<video controls width="` + strconv.Itoa(width) + `" height="` + strconv.Itoa(height) +
+			`" src="` + html.EscapeString(mediaURL(c, data, shownIdx, slide.Video.URL, false)) + `"></video>`
+	} else if slide.ThumbnailURL != "" {
+		res.Type = "photo"
+		res.Width, res.Height = width, height
+		res.URL = mediaURL(c, data, shownIdx, slide.ThumbnailURL, false)
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// Returns the oEmbed endpoint URL describing the post embedded on this page
+func oembedURL(c *gin.Context, data *utils.HtmlData, slideIdx int) string {
+	target := data.Permalink
+	if slideIdx > 0 {
+		target += "?img_index=" + strconv.Itoa(slideIdx)
+	}
+
+	link := requestOrigin(c) + "/oembed?format=json&url=" + url.QueryEscape(target)
+
+	// Keep the language the embed was requested in
+	if lang := c.Query("lang"); lang != "" {
+		link += "&lang=" + url.QueryEscape(lang)
+	}
+
+	return link
+}
+
+// Scales the media dimensions down to maxwidth and maxheight if the consumer asked for it
+func fitOEmbed(c *gin.Context, width, height int) (int, int) {
+	if width <= 0 || height <= 0 {
+		return width, height
+	}
+
+	if maxWidth, err := strconv.Atoi(c.Query("maxwidth")); err == nil && maxWidth > 0 && width > maxWidth {
+		height = height * maxWidth / width
+		width = maxWidth
+	}
+
+	if maxHeight, err := strconv.Atoi(c.Query("maxheight")); err == nil && maxHeight > 0 && height > maxHeight {
+		width = width * maxHeight / height
+		height = maxHeight
+	}
+
+	return width, height
+}
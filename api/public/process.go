@@ -19,27 +19,16 @@ package public
 
 import (
 	"bitwise7/vxinst/flags"
+	"bitwise7/vxinst/mediacache"
+	"bitwise7/vxinst/shortcode"
 	"bitwise7/vxinst/utils"
-	"context"
-	"fmt"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"strconv"
-	"strings"
-	"time"
+	"sync"
 
-	"github.com/getsentry/sentry-go"
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
-)
-
-var (
-	ctx                  = context.Background()
-	scrapingMethodsFuncs = map[string]func(postId string) (*utils.HtmlData, error){
-		"html": utils.ScrapeFromHTML,
-		// "graphql": utils.ScrapeFromGQL,
-		// "api":     utils.FetchPost,
-	}
 )
 
 type HtmlOpenGraphData struct {
@@ -48,116 +37,143 @@ type HtmlOpenGraphData struct {
 	VideoURL    string
 	ImageURL    string
 	PostURL     string
+	OEmbedURL   string
+	// Rendered into the description by the templates
+	Likes    int
+	Comments int
+	Views    int
+	Music    *utils.MusicData
+	Locale   locale
 }
 
 // Shared portion between some endpoints that do the same thing with minor
-// differences. Post ID must be specified since it's returned in different ways for each endpoint
+// differences. Post ID must be specified since it's returned in different ways for each endpoint.
+// It can be either a shortcode or a numeric media ID
 func (h *Handler) ProcessPost(c *gin.Context, postId string) {
 	slog.Debug("Got a request to process post", slog.String("id", postId))
 
-	if postId == "" || postId[0] != 'D' && postId[0] != 'C' {
+	// Media IDs are accepted too, but everything past here works with shortcodes
+	postId, ok := shortcode.Parse(postId)
+	if !ok {
 		slog.Debug("Invalid post id provided")
 		c.HTML(http.StatusOK, "not_found.html", "")
 		return
 	}
 
-	create := false
-	var data *utils.HtmlData
-	if err := h.Db.Model(&utils.HtmlData{}).Where("shortcode = ?", postId).First(&data).Error; err != nil {
-		create = true
-
-		if err == gorm.ErrRecordNotFound {
-			slog.Debug("No record found. Fetching new data")
-		} else {
-			slog.Error("Failed to read cache from database", slog.Any("err", err))
-		}
+	mode, _ := requestMode(c)
 
-		for _, method := range *flags.ScrapingMethods {
-			fn, ok := scrapingMethodsFuncs[method]
+	// People clicking the link want the post, not our embed page
+	if mode != modeDirect && !wantsEmbed(c) {
+		c.Redirect(http.StatusFound, permalink(postId, slideIndex(c)))
+		return
+	}
 
-			if !ok {
-				slog.Debug("Invalid scraping method", slog.String("method", method))
-				continue
-			}
+	data, err := h.Store.Get(c.Request.Context(), postId)
+	if err != nil {
+		data = nil
+	}
 
-			slog.Debug("Trying method", slog.String("method", method))
+	// Case 1: No data at all
+	if data == nil {
+		slog.Debug("No data found in database or from scraping")
+		c.HTML(http.StatusOK, "not_found.html", "")
+		return
+	}
 
-			data, err = fn(postId)
-			if err != nil {
-				slog.Error("Method failed, trying something else if available", slog.Any("err", err))
-				continue
-			}
+	slideIdx := slideIndex(c)
+	slide := data.Slide(slideIdx)
 
-			if data == nil {
-				slog.Error("Method didn't get any data, trying something else if available")
-				continue
-			} else {
-				slog.Debug("Found some data")
-			}
+	if mode == modeDirect {
+		serveDirect(c, data, slideIdx, slide)
+		return
+	}
 
-			fmt.Println(data)
+	if mode == modeText {
+		serveText(c, data)
+		return
+	}
 
-			break
-		}
-	} else {
-		slog.Debug("Found record in database")
+	loc := requestLocale(c)
+	title := loc.PostBy + data.Author.Username
+	if slideIdx > len(data.Media) {
+		slideIdx = 0
 	}
 
-	if create {
-		slog.Debug("Creating new record in database")
+	// Index of the slide actually shown
+	shownIdx := max(slideIdx, 1)
 
-		newRecord := &utils.HtmlData{
-			Shortcode: postId,
-			ExpiresAt: time.Now().Add(time.Hour * time.Duration(24*(*flags.MemoryLifetime))).Unix(),
-		}
-
-		if data != nil {
-			newRecord = data
-			newRecord.ExpiresAt = time.Now().Add(time.Hour * time.Duration(24*(*flags.MemoryLifetime))).Unix()
-		}
+	if slideIdx != 0 && len(data.Media) > 1 {
+		title += " (" + strconv.Itoa(slideIdx) + "/" + strconv.Itoa(len(data.Media)) + ")"
+	}
 
-		if err := h.Db.Model(&utils.HtmlData{}).Create(newRecord).Error; err != nil {
-			sentry.CaptureException(err)
-			slog.Error("Failed to save record to memory database", slog.Any("err", err))
+	// Popular posts get requested over and over, so have the media on disk by
+	// the time chat clients come asking for it
+	if h.MediaCache != nil && slide != nil {
+		if slide.Video != nil {
+			h.MediaCache.Warm(mediacache.Key(data.Shortcode, shownIdx, false), slide.Video.URL)
+		} else if slide.ThumbnailURL != "" {
+			h.MediaCache.Warm(mediacache.Key(data.Shortcode, shownIdx, true), slide.ThumbnailURL)
 		}
 	}
 
-	// Case 1: No data at all
-	if data == nil {
-		slog.Debug("No data found in database or from scraping")
-		c.HTML(http.StatusOK, "not_found.html", "")
-		return
+	var music *utils.MusicData
+	if showMusic(c) {
+		music = data.Music
 	}
 
-	var sb strings.Builder
-
-	sb.WriteString("❤️: ")
-	sb.WriteString(strconv.Itoa(data.Likes))
-	sb.WriteString(" 💬: ")
-	sb.WriteString(strconv.Itoa(data.Comments))
-	sb.WriteString(" 👁️: ")
-	sb.WriteString(strconv.Itoa(data.Views))
-
 	// No video but image available
-	if data.Video == nil && data.ThumbnailURL != "" {
+	if slide != nil && slide.Video == nil && slide.ThumbnailURL != "" {
 		slog.Debug("Post didn't have a video but we found an image to show")
 
+		imageURL := mediaURL(c, data, shownIdx, slide.ThumbnailURL, false)
+
+		// Show every image at once unless the user linked a specific slide
+		if slideIdx == 0 && len(utils.MosaicImages(data)) > 1 {
+			imageURL = requestOrigin(c) + "/mosaic/" + data.Shortcode
+		}
+
+		if mode == modeGallery {
+			c.HTML(http.StatusOK, "gallery.html", &HtmlOpenGraphData{
+				ImageURL: imageURL,
+				PostURL:  data.Permalink,
+			})
+			return
+		}
+
 		c.HTML(http.StatusOK, "image.html", &HtmlOpenGraphData{
-			Title:       "Post by @" + data.Author.Username,
-			ImageURL:    data.ThumbnailURL,
-			PostURL:     data.Permalink,
-			Description: sb.String(),
+			Title:     title,
+			ImageURL:  imageURL,
+			PostURL:   data.Permalink,
+			OEmbedURL: oembedURL(c, data, slideIdx),
+			Likes:     data.Likes,
+			Comments:  data.Comments,
+			Views:     data.Views,
+			Music:     music,
+			Locale:    loc,
 		})
 		return
 	}
 
 	// Video found
-	if data.Video != nil {
+	if slide != nil && slide.Video != nil {
+		if mode == modeGallery {
+			c.HTML(http.StatusOK, "gallery.html", &HtmlOpenGraphData{
+				VideoURL: mediaURL(c, data, shownIdx, slide.Video.URL, false),
+				PostURL:  data.Permalink,
+			})
+			return
+		}
+
 		c.HTML(http.StatusOK, "video.html", &HtmlOpenGraphData{
-			Title:       "Post by @" + data.Author.Username,
-			Description: sb.String(),
-			PostURL:     data.Permalink,
-			VideoURL:    data.Video.URL,
+			Title:     title,
+			PostURL:   data.Permalink,
+			VideoURL:  mediaURL(c, data, shownIdx, slide.Video.URL, false),
+			OEmbedURL: oembedURL(c, data, slideIdx),
+			Likes:     data.Likes,
+			Comments:  data.Comments,
+			Views:     data.Views,
+			Music:     music,
+			Locale:    loc,
 		})
 		return
 	}
@@ -167,3 +183,114 @@ func (h *Handler) ProcessPost(c *gin.Context, postId string) {
 		PostURL: "https://instagram.com/p/" + postId,
 	})
 }
+
+// Redirects to the video of the slide, or its image if it doesn't have one
+func serveDirect(c *gin.Context, data *utils.HtmlData, slideIdx int, slide *utils.MediaItem) {
+	index := max(slideIdx, 1)
+	if slideIdx > len(data.Media) {
+		index = 1
+	}
+
+	switch {
+	case slide != nil && slide.Video != nil:
+		c.Redirect(http.StatusFound, mediaURL(c, data, index, slide.Video.URL, false))
+	case slide != nil && slide.ThumbnailURL != "":
+		c.Redirect(http.StatusFound, mediaURL(c, data, index, slide.ThumbnailURL, false))
+	default:
+		c.HTML(http.StatusNotFound, "not_found.html", "")
+	}
+}
+
+// Returns the 1-based carousel slide requested either through the :index path
+// param (/p/:id/:index) or instagram's own ?img_index= query. Returns 0 if no
+// valid slide was requested
+func slideIndex(c *gin.Context) int {
+	raw := c.Param("index")
+	if raw == "" {
+		raw = c.Query("img_index")
+	}
+
+	index, err := strconv.Atoi(raw)
+	if err != nil || index < 1 {
+		return 0
+	}
+
+	return index
+}
+
+// Reports if the song used in the post should be shown. The ?music= query
+// overrides --hide-music
+func showMusic(c *gin.Context) bool {
+	if show, err := strconv.ParseBool(c.Query("music")); err == nil {
+		return show
+	}
+
+	return !*flags.HideMusic
+}
+
+// Reports if the client should get an embed instead of a redirect to
+// instagram. The ?embed= query overrides the user agent detection
+func wantsEmbed(c *gin.Context) bool {
+	if embed, err := strconv.ParseBool(c.Query("embed")); err == nil {
+		return embed
+	}
+
+	return utils.IsBot(c.Request.UserAgent())
+}
+
+// Returns the instagram link to the post, pointing at the given slide if any
+func permalink(postId string, slideIdx int) string {
+	link := "https://www.instagram.com/p/" + postId + "/"
+	if slideIdx > 0 {
+		link += "?img_index=" + strconv.Itoa(slideIdx)
+	}
+
+	return link
+}
+
+// Returns the scheme and host links pointing back at us (for example og:image)
+// should use. Without --public-url the Host header is used, which the response
+// cache has to take into account
+func requestOrigin(c *gin.Context) string {
+	if *flags.PublicURL != "" {
+		return *flags.PublicURL
+	}
+
+	scheme := "http"
+	if *flags.Secure || (fromTrustedProxy(c) && c.GetHeader("X-Forwarded-Proto") == "https") {
+		scheme = "https"
+	}
+
+	return scheme + "://" + c.Request.Host
+}
+
+var trustedProxies = sync.OnceValue(func() []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(*flags.TrustedProxies))
+
+	for _, proxy := range *flags.TrustedProxies {
+		if prefix, err := netip.ParsePrefix(proxy); err == nil {
+			prefixes = append(prefixes, prefix)
+		} else if addr, err := netip.ParseAddr(proxy); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+
+	return prefixes
+})
+
+// Reports if the request came straight from one of --trusted-proxies, so
+// headers like X-Forwarded-Proto can be believed
+func fromTrustedProxy(c *gin.Context) bool {
+	addr, err := netip.ParseAddr(c.RemoteIP())
+	if err != nil {
+		return false
+	}
+
+	for _, prefix := range trustedProxies() {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,90 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package public
+
+import (
+	"bitwise7/vxinst/scraper"
+	"bitwise7/vxinst/store"
+	"bitwise7/vxinst/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Browsers get redirected to instagram before the post is looked up, so the
+// redirect shows which post and slide a route resolved to without scraping anything
+func TestPostRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&utils.HtmlData{}, &utils.ShareLink{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Already resolved so following them doesn't need instagram
+	db.Create(&utils.ShareLink{ID: "reel/BAB1Nc2Yp7q", Shortcode: "DGnYkxfSDvL", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	db.Create(&utils.ShareLink{ID: "/BAB1Nc2Yp7q", Shortcode: "C8m1Qv-Ox_Z", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+	h := &Handler{Router: gin.New(), Store: store.New(db, scraper.NewRegistry())}
+	h.routes()
+
+	tests := []struct {
+		path     string
+		location string
+	}{
+		{"/p/DGnYkxfSDvL", "https://www.instagram.com/p/DGnYkxfSDvL/"},
+		{"/p/DGnYkxfSDvL/2", "https://www.instagram.com/p/DGnYkxfSDvL/?img_index=2"},
+		{"/p/DGnYkxfSDvL?img_index=3", "https://www.instagram.com/p/DGnYkxfSDvL/?img_index=3"},
+		{"/p/DGnYkxfSDvL?igsh=MWQ1ZGUxMzBkMA==", "https://www.instagram.com/p/DGnYkxfSDvL/"},
+		{"/reel/DGnYkxfSDvL", "https://www.instagram.com/p/DGnYkxfSDvL/"},
+		{"/reels/DGnYkxfSDvL", "https://www.instagram.com/p/DGnYkxfSDvL/"},
+		{"/reels/DGnYkxfSDvL/2", "https://www.instagram.com/p/DGnYkxfSDvL/?img_index=2"},
+		{"/reels/videos/DGnYkxfSDvL", "https://www.instagram.com/p/DGnYkxfSDvL/"},
+		{"/tv/DGnYkxfSDvL", "https://www.instagram.com/p/DGnYkxfSDvL/"},
+		{"/someone/p/DGnYkxfSDvL", "https://www.instagram.com/p/DGnYkxfSDvL/"},
+		{"/someone/p/DGnYkxfSDvL/3", "https://www.instagram.com/p/DGnYkxfSDvL/?img_index=3"},
+		{"/someone/reel/DGnYkxfSDvL", "https://www.instagram.com/p/DGnYkxfSDvL/"},
+		{"/someone/tv/DGnYkxfSDvL", "https://www.instagram.com/p/DGnYkxfSDvL/"},
+		{"/g/reels/videos/DGnYkxfSDvL", "https://www.instagram.com/p/DGnYkxfSDvL/"},
+		{"/share/reel/BAB1Nc2Yp7q", "https://www.instagram.com/p/DGnYkxfSDvL/"},
+		{"/share/BAB1Nc2Yp7q", "https://www.instagram.com/p/C8m1Qv-Ox_Z/"},
+		{"/g/share/reel/BAB1Nc2Yp7q", "https://www.instagram.com/p/DGnYkxfSDvL/"},
+		{"/t/share/BAB1Nc2Yp7q", "https://www.instagram.com/p/C8m1Qv-Ox_Z/"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:135.0) Gecko/20100101 Firefox/135.0")
+
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusFound || w.Header().Get("Location") != tt.location {
+			t.Errorf("GET %s = %d %q; want %d %q", tt.path, w.Code, w.Header().Get("Location"), http.StatusFound, tt.location)
+		}
+	}
+}
@@ -18,52 +18,48 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package public
 
 import (
+	"bitwise7/vxinst/utils"
+	"errors"
 	"log/slog"
 	"net/http"
-	"strings"
 
 	"github.com/getsentry/sentry-go"
 	"github.com/gin-gonic/gin"
 )
 
-var (
-	client = &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return nil
-		},
-	}
-)
-
 // Videos shared from the phone generate a redirect
 // ID, then redirect the user to the actual post.
 // This means we have to first follow the redirects before we can actually embed
 // the post itself which is extremely annoying and slow.
-// Lots of fuckery and workarounds just to support one edge case.
+// Resolved IDs are stored so we only have to do this once per link.
 func (h *Handler) FollowShare(c *gin.Context) {
+	kind, shareId := c.Param("kind"), c.Param("id")
+	// Old /share/<id> links don't have a kind
+	if shareId == "" {
+		kind, shareId = "", kind
+	}
+
+	h.followShare(c, kind, shareId)
+}
+
+func (h *Handler) followShare(c *gin.Context, kind, shareId string) {
 	span := sentry.StartSpan(c.Request.Context(), "share.parse")
 	defer span.Finish()
 
-	req, err := http.NewRequest("GET", "https://instagram.com"+c.Request.URL.String(), nil)
+	postId, err := h.Store.ResolveShare(c.Request.Context(), kind, shareId)
 	if err != nil {
-		slog.Error("Failed to prepare request to follow redirects", slog.Any("err", err))
-		sentry.CaptureException(err)
+		if errors.Is(err, utils.ErrNotAPost) {
+			slog.Debug("Share link doesn't point at a post", slog.String("id", shareId))
+			c.HTML(http.StatusOK, "not_found.html", "")
+			return
+		}
 
-		c.HTML(http.StatusOK, "failed.html", "")
-		return
-	}
-
-	res, err := client.Do(req)
-	if err != nil {
-		slog.Error("Failed to follow redirects", slog.Any("err", err))
+		slog.Error("Failed to resolve share link", slog.Any("err", err))
 		sentry.CaptureException(err)
 
 		c.HTML(http.StatusOK, "failed.html", "")
 		return
 	}
-	res.Body.Close()
-
-	urlSplit := strings.Split(res.Request.URL.String(), "/")
-	postId := urlSplit[len(urlSplit)-2]
 
 	h.ProcessPost(c, postId)
 }
@@ -0,0 +1,76 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package public
+
+import (
+	"bitwise7/vxinst/utils"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Discord cuts off longer descriptions
+	discordDescriptionLimit = 350
+	// Telegram shows a lot more text in link previews than everyone else
+	telegramDescriptionLimit = 1024
+)
+
+// Embeds the caption instead of the media. Hashtags and mentions that get
+// cut off from a long caption are listed at the end so they aren't lost
+func serveText(c *gin.Context, data *utils.HtmlData) {
+	limit := descriptionLimit(c)
+
+	description := utils.Truncate(data.Title, limit)
+
+	if description != data.Title {
+		tags := append(utils.Mentions(data.Title), utils.Hashtags(data.Title)...)
+		// Tags can't push the caption out entirely
+		reserved := utils.Truncate(strings.Join(tags, " "), limit/3)
+
+		kept := utils.Truncate(data.Title, limit-utf8.RuneCountInString(reserved)-2)
+
+		var missing []string
+		for _, tag := range tags {
+			if !strings.Contains(kept, tag) {
+				missing = append(missing, tag)
+			}
+		}
+
+		if len(missing) > 0 {
+			description = kept + "\n\n" + utils.Truncate(strings.Join(missing, " "), limit/3)
+		}
+	}
+
+	c.HTML(http.StatusOK, "text.html", &HtmlOpenGraphData{
+		Title:       requestLocale(c).PostBy + data.Author.Username,
+		Description: description,
+		PostURL:     data.Permalink,
+	})
+}
+
+// Returns how much of the caption the client requesting the embed shows
+func descriptionLimit(c *gin.Context) int {
+	if strings.Contains(strings.ToLower(c.Request.UserAgent()), "telegrambot") {
+		return telegramDescriptionLimit
+	}
+
+	return discordDescriptionLimit
+}
@@ -17,6 +17,38 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 */
 package public
 
-import "github.com/gin-gonic/gin"
+import (
+	"bitwise7/vxinst/utils"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
 
 func (h *Handler) ServeVideo(c *gin.Context) { h.ProcessPost(c, c.Param("id")) }
+
+// Catches every URL shape not covered by the regular routes, like links with
+// the username in front (/<username>/reel/<postId>) or any of them behind a
+// mode prefix (/d/reel/<postId>, /d/share/reel/<shareId>)
+func (h *Handler) ServeAnyPost(c *gin.Context) {
+	_, path := requestMode(c)
+
+	if kind, shareId, ok := utils.ParseShareURL(path); ok {
+		h.followShare(c, kind, shareId)
+		return
+	}
+
+	postId, slide, ok := utils.ParsePostURL(path)
+	if !ok {
+		slog.Debug("Unknown URL shape", slog.String("path", c.Request.URL.Path))
+		c.HTML(http.StatusNotFound, "not_found.html", "")
+		return
+	}
+
+	if slide > 0 {
+		c.Params = append(c.Params, gin.Param{Key: "index", Value: strconv.Itoa(slide)})
+	}
+
+	h.ProcessPost(c, postId)
+}
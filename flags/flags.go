@@ -20,6 +20,7 @@ package flags
 import (
 	"io/fs"
 	"log/slog"
+	"net/url"
 	"os"
 	"slices"
 	"strconv"
@@ -38,9 +39,19 @@ var (
 	CertFile  = pflag.StringP("cert-file", "C", getEnvDefault("CERT_FILE", ""), "Path to the SSL certificate (only needed with secure enabled)")
 	KeyFile   = pflag.StringP("key-file", "K", getEnvDefault("KEY_FILE", ""), "Path to the SSL key (only needed with secure enabled)")
 	SentryDsn = pflag.StringP("sentry-dsn", "d", getEnvDefault("SENTRY_DSN", ""), "Sentry DSN used for telemetry")
+	PublicURL = pflag.String("public-url", getEnvDefault("PUBLIC_URL", ""), "URL the server is reachable at (like https://vxinst.com). Links pointing back at the server use it instead of the Host header")
 
-	CacheLifetime  = pflag.IntP("cache-lifetime", "L", getEnvDefaultInt("CACHE_LIFETIME", 60), "Cache lifetime (in minutes)")
-	MemoryLifetime = pflag.IntP("memory-lifetime", "M", getEnvDefaultInt("MEMORY_LIFETIME", 7), "Memory cache lifetime (in days)")
+	CacheLifetime    = pflag.IntP("cache-lifetime", "L", getEnvDefaultInt("CACHE_LIFETIME", 60), "Cache lifetime (in minutes)")
+	MemoryLifetime   = pflag.IntP("memory-lifetime", "M", getEnvDefaultInt("MEMORY_LIFETIME", 7), "Memory cache lifetime (in days)")
+	StaleWindow      = pflag.Int("stale-window", getEnvDefaultInt("STALE_WINDOW", 120), "Refresh posts in the background once their media links expire within this time (in minutes)")
+	NegativeLifetime = pflag.Int("negative-lifetime", getEnvDefaultInt("NEGATIVE_LIFETIME", 5), "How long to remember posts that couldn't be scraped (in minutes)")
+
+	RateLimit      = pflag.Int("rate-limit", getEnvDefaultInt("RATE_LIMIT", 5), "Requests per second each client can make")
+	RateBurst      = pflag.Int("rate-burst", getEnvDefaultInt("RATE_BURST", 10), "Requests each client can make at once before being limited")
+	TrustedProxies = pflag.StringArray("trusted-proxies", getEnvDefaultStringSlice("TRUSTED_PROXIES", []string{}), "IPs or CIDRs of reverse proxies allowed to set the client IP (X-Forwarded-For, X-Real-IP, CF-Connecting-IP)")
+	RateLimitAllow = pflag.StringArray("rate-limit-allow", getEnvDefaultStringSlice("RATE_LIMIT_ALLOW", []string{"Discordbot", "TelegramBot", "Slackbot", "Twitterbot", "facebookexternalhit", "WhatsApp", "Mastodon"}), "User agents (or parts of them) that aren't rate limited")
+
+	BotAgents = pflag.StringArray("bot-agents", getEnvDefaultStringSlice("BOT_AGENTS", []string{"bot", "facebookexternalhit", "WhatsApp", "Mastodon", "Pleroma", "Misskey", "Synapse", "Iframely", "Embedly", "vkShare", "SkypeUriPreview", "crawler", "spider", "preview"}), "User agents (or parts of them) that get embeds. Everyone else is redirected to instagram")
 
 	RedisEnable = pflag.BoolP("redis-enable", "r", getEnvDefaultBool("REDIS_ENABLE", false), "Enables redis")
 	RedisAddr   = pflag.StringP("redis-address", "A", getEnvDefault("REDIS_ADDR", ""), "Address to redis database for caching")
@@ -53,7 +64,19 @@ var (
 	InstagramXIGAppID     = pflag.String("insta-xigappid", getEnvDefault("INSTA_XIGAPPID", ""), "X-IG-App-ID to fetch content")
 	InstagramBrowserAgent = pflag.String("insta-browser-agent", getEnvDefault("INSTA_BROWSER_AGENT", "Mozilla/5.0 (X11; Linux x86_64; rv:135.0) Gecko/20100101 Firefox/135.0"), "Instagram browser agent to use")
 
-	ScrapingMethods = pflag.StringArray("scraping-methods", getEnvDefaultStringSlice("SCRAPING_METHODS", []string{"html"}), "Scraping methods to use. Available: html, graphql")
+	HideEmptyStats    = pflag.Bool("hide-empty-stats", getEnvDefaultBool("HIDE_EMPTY_STATS", true), "Leave out likes, comments and views that are zero from embeds. Instagram returns 0 for hidden counts")
+	HideMusic         = pflag.Bool("hide-music", getEnvDefaultBool("HIDE_MUSIC", false), "Don't show the song used in reels in embeds (can be overridden with ?music=1)")
+	ProxyMedia        = pflag.Bool("proxy-media", getEnvDefaultBool("PROXY_MEDIA", false), "Make embeds load media through the server instead of instagram's CDN")
+	MediaSecrets      = pflag.StringArray("media-secrets", getEnvDefaultStringSlice("MEDIA_SECRETS", []string{}), "Secrets to sign media links with. The first one signs, the rest are accepted so links survive a rotation")
+	MediaLinkLifetime = pflag.Int("media-link-lifetime", getEnvDefaultInt("MEDIA_LINK_LIFETIME", 24), "How long signed media links stay valid (in hours)")
+	MediaCacheDir     = pflag.String("media-cache-dir", getEnvDefault("MEDIA_CACHE_DIR", ""), "Directory to keep fetched media in. Disabled if empty")
+	MediaCacheSize    = pflag.Int("media-cache-size", getEnvDefaultInt("MEDIA_CACHE_SIZE", 1024), "Max size of the media cache (in megabytes)")
+	MosaicDir         = pflag.String("mosaic-dir", getEnvDefault("MOSAIC_DIR", "mosaics"), "Directory to store composed carousel images in")
+
+	ScrapingMethods = pflag.StringArray("scraping-methods", getEnvDefaultStringSlice("SCRAPING_METHODS", []string{"html"}), "Scraping methods to use. Available: html, graphql, api")
+	ScrapingRace    = pflag.Bool("scraping-race", getEnvDefaultBool("SCRAPING_RACE", false), "Run all scraping methods at once and use the first one to find the post")
+	GraphQLDocID    = pflag.String("graphql-doc-id", getEnvDefault("GRAPHQL_DOC_ID", "8845758582119845"), "Document ID of the GraphQL query used to fetch posts")
+	StatsToken      = pflag.String("stats-token", getEnvDefault("STATS_TOKEN", ""), "Token needed to access /api/scraperStats (as \"Authorization: Bearer <token>\"). The endpoint is disabled if empty")
 
 	logLevels = []string{"debug", "info", "warn", "error"}
 )
@@ -141,6 +164,47 @@ func Parse() {
 		os.Exit(1)
 	}
 
+	if *StaleWindow < 0 {
+		slog.Error("Stale window can't be negative", slog.Int("window", *StaleWindow))
+		os.Exit(1)
+	}
+
+	if *StaleWindow < *CacheLifetime {
+		slog.Warn("Stale window is shorter than the cache lifetime. Cached responses may contain expired media links", slog.Int("window", *StaleWindow), slog.Int("lifetime", *CacheLifetime))
+	}
+
+	if *RateLimit <= 0 || *RateBurst <= 0 {
+		slog.Error("Rate limit and burst must be greater than 0", slog.Int("limit", *RateLimit), slog.Int("burst", *RateBurst))
+		os.Exit(1)
+	}
+
+	if *NegativeLifetime <= 0 {
+		slog.Error("Negative cache lifetime must be greater than 0", slog.Int("lifetime", *NegativeLifetime))
+		os.Exit(1)
+	}
+
+	if *MediaLinkLifetime <= 0 {
+		slog.Error("Media link lifetime must be greater than 0", slog.Int("lifetime", *MediaLinkLifetime))
+		os.Exit(1)
+	}
+
+	if *MediaCacheDir != "" && *MediaCacheSize <= 0 {
+		slog.Error("Media cache size must be greater than 0", slog.Int("size", *MediaCacheSize))
+		os.Exit(1)
+	}
+
+	if *PublicURL != "" {
+		u, err := url.Parse(*PublicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			slog.Error("Public URL must be an absolute http or https URL", slog.String("url", *PublicURL))
+			os.Exit(1)
+		}
+
+		*PublicURL = strings.TrimRight(*PublicURL, "/")
+	} else {
+		slog.Warn("No public URL provided. Links back to the server will be built from the Host header of each request")
+	}
+
 	if *RedisEnable && *RedisDB == -1 {
 		slog.Error("No redis database provided")
 		os.Exit(1)
@@ -198,6 +262,20 @@ func Parse() {
 		slog.Warn("No proxies provided. You're prone to rate limiting and being ip banned")
 	}
 
+	hasApiCredentials := *InstagramCookie != "" && *InstagramXIGAppID != "" && *InstagramBrowserAgent != ""
+
+	// Age-restricted posts can only be fetched from the API, so use it as the last resort
+	// if credentials are configured and the user didn't pick the methods themselves
+	_, methodsFromEnv := os.LookupEnv("SCRAPING_METHODS")
+	if hasApiCredentials && !pflag.CommandLine.Changed("scraping-methods") && !methodsFromEnv && !slices.Contains(*ScrapingMethods, "api") {
+		*ScrapingMethods = append(*ScrapingMethods, "api")
+	}
+
+	if slices.Contains(*ScrapingMethods, "graphql") && *GraphQLDocID == "" {
+		slog.Error("GraphQL scraping is enabled but no document ID was provided")
+		os.Exit(1)
+	}
+
 	if *InstagramCookie == "" {
 		slog.Warn("No instagram cookie provided. The server won't attempt to make API requests for age-restricted reels")
 	}
@@ -20,6 +20,9 @@ package main
 import (
 	"bitwise7/vxinst/api/public"
 	"bitwise7/vxinst/flags"
+	"bitwise7/vxinst/mediacache"
+	"bitwise7/vxinst/scraper"
+	"bitwise7/vxinst/store"
 	"bitwise7/vxinst/utils"
 	"log/slog"
 	"os"
@@ -55,11 +58,26 @@ func main() {
 		os.Exit(1)
 	}
 
-	h := public.NewHandler(db)
+	scrapers, err := scraper.NewDefaultRegistry()
+	if err != nil {
+		slog.Error("Failed to set up scraping methods", slog.Any("err", err))
+		os.Exit(1)
+	}
+
+	var mediaCache *mediacache.Cache
+	if *flags.MediaCacheDir != "" {
+		mediaCache, err = mediacache.New(*flags.MediaCacheDir, int64(*flags.MediaCacheSize)*1024*1024)
+		if err != nil {
+			slog.Error("Failed to initialize media cache", slog.Any("err", err))
+			os.Exit(1)
+		}
+	}
+
+	h := public.NewHandler(db, store.New(db, scrapers), mediaCache)
 	h.Init()
 
 	// Initialize ticker for database cleanup
-	go cleanDb(db)
+	go cleanDb(db, mediaCache)
 
 	if *flags.Secure {
 		slog.Info("Server running with TLS enabled", slog.String("listen", *flags.Port))
@@ -71,8 +89,9 @@ func main() {
 
 }
 
-// Periodically cleans up expired records from the database
-func cleanDb(db *gorm.DB) {
+// Periodically cleans up expired records from the database along with the
+// files that belonged to them
+func cleanDb(db *gorm.DB, mediaCache *mediacache.Cache) {
 	ticker := time.NewTicker(5 * time.Minute)
 
 	for range ticker.C {
@@ -99,6 +118,7 @@ func cleanDb(db *gorm.DB) {
 
 		tx := db.Begin()
 		tx.Model(&utils.HtmlData{}).Where("shortcode IN ?", toDelete).Delete(nil)
+		tx.Where("expires_at < ?", time.Now().Unix()).Delete(&utils.ShareLink{})
 
 		if err := tx.Commit().Error; err != nil {
 			slog.Error("Failed to commit database transaction", slog.Any("err", err.Error))
@@ -106,5 +126,27 @@ func cleanDb(db *gorm.DB) {
 		} else {
 			slog.Debug("Old records deleted")
 		}
+
+		for _, shortcode := range toDelete {
+			if err := os.Remove(utils.MosaicPath(*flags.MosaicDir, shortcode)); err != nil && !os.IsNotExist(err) {
+				slog.Error("Failed to remove mosaic", slog.String("shortcode", shortcode), slog.Any("err", err))
+			}
+		}
+
+		if mediaCache != nil {
+			remaining := []string{}
+
+			if err := db.Model(&utils.HtmlData{}).Where("not_found = ?", false).Pluck("shortcode", &remaining).Error; err != nil {
+				slog.Error("Failed to list records for media cleanup", slog.Any("err", err))
+				continue
+			}
+
+			known := make(map[string]bool, len(remaining))
+			for _, shortcode := range remaining {
+				known[shortcode] = true
+			}
+
+			mediaCache.RemoveOrphans(func(shortcode string) bool { return known[shortcode] })
+		}
 	}
 }
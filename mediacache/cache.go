@@ -0,0 +1,343 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package mediacache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Keeps fetched videos and images on disk so they can still be served after
+// their CDN links expire. Once the total size goes over the budget the least
+// recently used files are evicted
+type Cache struct {
+	dir    string
+	budget int64
+	client *http.Client
+
+	mutex   sync.Mutex
+	lru     *list.List // Most recently used at the front
+	entries map[string]*list.Element
+	size    int64
+
+	group singleflight.Group
+}
+
+type entry struct {
+	key  string
+	size int64
+}
+
+// Opens the cache in dir, picking up files left there by previous runs
+func New(dir string, budget int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create media cache directory: %v", err)
+	}
+
+	c := &Cache{
+		dir:    dir,
+		budget: budget,
+		client: &http.Client{
+			Timeout: 2 * time.Minute,
+		},
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read media cache directory: %v", err)
+	}
+
+	type existing struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+
+	found := []existing{}
+
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+
+		// Leftovers of downloads interrupted by a restart
+		if strings.HasPrefix(f.Name(), ".") {
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+
+		found = append(found, existing{f.Name(), info.Size(), info.ModTime()})
+	}
+
+	// Oldest first so the most recently used file ends up at the front
+	slices.SortFunc(found, func(a, b existing) int {
+		return a.modTime.Compare(b.modTime)
+	})
+
+	for _, f := range found {
+		c.add(f.key, f.size)
+	}
+
+	c.mutex.Lock()
+	c.evict()
+	c.mutex.Unlock()
+
+	slog.Info("Media cache ready", slog.String("dir", dir), slog.Int("files", c.lru.Len()), slog.Int64("bytes", c.size))
+
+	return c, nil
+}
+
+// Returns the cache key for the media of a post slide
+func Key(shortcode string, index int, thumbnail bool) string {
+	key := shortcode + "." + strconv.Itoa(index)
+
+	if thumbnail {
+		key += ".thumb"
+	}
+
+	return key
+}
+
+// Returns the shortcode a key belongs to
+func shortcodeOf(key string) string {
+	shortcode, _, _ := strings.Cut(key, ".")
+	return shortcode
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// Opens the cached file for the key. The caller must close the file
+func (c *Cache) Open(key string) (*os.File, os.FileInfo, bool) {
+	c.mutex.Lock()
+	el, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(el)
+	}
+	c.mutex.Unlock()
+
+	if !ok {
+		return nil, nil, false
+	}
+
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		// Someone removed the file behind our back
+		c.Remove(key)
+		return nil, nil, false
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, false
+	}
+
+	// Keeps the order of files across restarts. Not a big deal if it fails
+	now := time.Now()
+	os.Chtimes(c.path(key), now, now)
+
+	return f, info, true
+}
+
+func (c *Cache) Has(key string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, ok := c.entries[key]
+	return ok
+}
+
+// Downloads the media at url into the cache unless it's already there.
+// Concurrent calls for the same key share a single download
+func (c *Cache) Fetch(ctx context.Context, key, url string) error {
+	if c.Has(key) {
+		return nil
+	}
+
+	_, err, _ := c.group.Do(key, func() (any, error) {
+		if c.Has(key) {
+			return nil, nil
+		}
+
+		return nil, c.download(ctx, key, url)
+	})
+
+	return err
+}
+
+// Same as [Cache.Fetch] but doesn't wait for the download to finish
+func (c *Cache) Warm(key, url string) {
+	go func() {
+		if err := c.Fetch(context.Background(), key, url); err != nil {
+			slog.Debug("Failed to cache media", slog.String("key", key), slog.Any("err", err))
+		}
+	}()
+}
+
+func (c *Cache) download(ctx context.Context, key, url string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch media: status %d", res.StatusCode)
+	}
+
+	if res.ContentLength > c.budget {
+		return fmt.Errorf("media is larger than the whole cache: %d bytes", res.ContentLength)
+	}
+
+	// Write to a temporary file first so nobody ever reads a half written file
+	tmp, err := os.CreateTemp(c.dir, ".download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, io.LimitReader(res.Body, c.budget+1))
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if size > c.budget {
+		return fmt.Errorf("media is larger than the whole cache: over %d bytes", c.budget)
+	}
+
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		return err
+	}
+
+	c.add(key, size)
+
+	c.mutex.Lock()
+	c.evict()
+	c.mutex.Unlock()
+
+	return nil
+}
+
+func (c *Cache) add(key string, size int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*entry).size
+		c.lru.Remove(el)
+	}
+
+	c.entries[key] = c.lru.PushFront(&entry{key, size})
+	c.size += size
+}
+
+// Removes the least recently used files until we're within the budget.
+// Must be called with the mutex held
+func (c *Cache) evict() {
+	for c.size > c.budget && c.lru.Len() > 0 {
+		el := c.lru.Back()
+		e := el.Value.(*entry)
+
+		c.lru.Remove(el)
+		delete(c.entries, e.key)
+		c.size -= e.size
+
+		// Open files stay readable after being removed so this doesn't break
+		// anyone still streaming it
+		if err := os.Remove(c.path(e.key)); err != nil && !os.IsNotExist(err) {
+			slog.Error("Failed to evict cached media", slog.String("key", e.key), slog.Any("err", err))
+		}
+
+		slog.Debug("Evicted cached media", slog.String("key", e.key))
+	}
+}
+
+func (c *Cache) Remove(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	e := el.Value.(*entry)
+
+	c.lru.Remove(el)
+	delete(c.entries, key)
+	c.size -= e.size
+
+	if err := os.Remove(c.path(key)); err != nil && !os.IsNotExist(err) {
+		slog.Error("Failed to remove cached media", slog.String("key", key), slog.Any("err", err))
+	}
+}
+
+// Removes the files of every post that keep reports as gone
+func (c *Cache) RemoveOrphans(keep func(shortcode string) bool) {
+	c.mutex.Lock()
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	c.mutex.Unlock()
+
+	removed := 0
+
+	for _, key := range keys {
+		if !keep(shortcodeOf(key)) {
+			c.Remove(key)
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		slog.Debug("Removed orphaned media", slog.Int("files", removed))
+	}
+}
@@ -18,66 +18,195 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package middleware
 
 import (
+	"context"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	// Buckets of clients that haven't made a request in this long are dropped.
+	// They'd be full by now anyway
+	idleTimeout   = 10 * time.Minute
+	evictInterval = time.Minute
+)
+
+type Limiter interface {
+	// Takes a token from the bucket of the client identified by key
+	Allow(ctx context.Context, key string) Result
+	// Size of every client's bucket
+	Limit() int
+}
+
+// State of a client's bucket after a request
+type Result struct {
+	// False if the client was out of tokens
+	Allowed bool
+	// Size of the bucket
+	Limit int
+	// Whole tokens left in the bucket
+	Remaining int
+	// Time until the bucket is full again
+	Reset time.Duration
+	// Time until the client gets another token. Zero if there are tokens left
+	RetryAfter time.Duration
+}
+
+func newResult(allowed bool, tokens, bucket, rate float64) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     int(bucket),
+		Remaining: int(tokens),
+		Reset:     time.Duration((bucket - tokens) / rate * float64(time.Second)),
+	}
+
+	if tokens < 1 {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+
+	return result
+}
+
+// Headers from https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers/
+func (r Result) setHeaders(c *gin.Context) {
+	c.Header("RateLimit-Limit", strconv.Itoa(r.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(r.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(r.Reset)))
+
+	if !r.Allowed {
+		c.Header("Retry-After", strconv.Itoa(max(ceilSeconds(r.RetryAfter), 1)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// Token bucket rate limiter keeping a separate bucket for every client.
+// Only limits requests coming to this instance
 type RateLimiter struct {
-	rate   int
-	bucket int
-	tokens int
-	mutex  sync.Mutex
-	ticker *time.Ticker
+	rate    float64
+	bucket  float64
+	mutex   sync.Mutex
+	clients map[string]*clientBucket
 }
 
+type clientBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// Creates a limiter allowing each client rate requests per second with bursts
+// of up to bucket requests
 func NewRateLimiter(rate, bucket int) *RateLimiter {
 	limiter := &RateLimiter{
-		rate:   rate,
-		bucket: bucket,
-		tokens: bucket,
-		ticker: time.NewTicker(time.Second / time.Duration(rate)),
+		rate:    float64(rate),
+		bucket:  float64(bucket),
+		clients: map[string]*clientBucket{},
 	}
 
-	go limiter.refillTokens()
+	go limiter.evictIdle()
 
 	return limiter
 }
 
-func (r *RateLimiter) refillTokens() {
-	for range r.ticker.C {
+func (r *RateLimiter) evictIdle() {
+	ticker := time.NewTicker(evictInterval)
+
+	for range ticker.C {
 		r.mutex.Lock()
 
-		if r.tokens < r.bucket {
-			r.tokens++
+		for key, client := range r.clients {
+			if time.Since(client.lastSeen) > idleTimeout {
+				delete(r.clients, key)
+			}
 		}
 
 		r.mutex.Unlock()
 	}
 }
 
-func (r *RateLimiter) Allow() bool {
+func (r *RateLimiter) Limit() int {
+	return int(r.bucket)
+}
+
+func (r *RateLimiter) Allow(_ context.Context, key string) Result {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	if r.tokens > 0 {
-		r.tokens--
-		return true
+	now := time.Now()
+
+	client, ok := r.clients[key]
+	if !ok {
+		client = &clientBucket{tokens: r.bucket}
+		r.clients[key] = client
+	} else {
+		// Refill the tokens earned since the last request
+		client.tokens = min(r.bucket, client.tokens+now.Sub(client.lastSeen).Seconds()*r.rate)
+	}
+
+	client.lastSeen = now
+
+	allowed := client.tokens >= 1
+	if allowed {
+		client.tokens--
 	}
 
-	return false
+	return newResult(allowed, client.tokens, r.bucket, r.rate)
 }
 
-func RateLimiterMiddleware(limiter *RateLimiter) gin.HandlerFunc {
+// Limits requests per client IP. Requests with a user agent containing any
+// of allowedAgents (case insensitive) aren't limited, so chat clients
+// unfurling links can't get locked out by someone else.
+// Rejected requests get a "slow down" page, except for API endpoints which get JSON
+func RateLimiterMiddleware(limiter Limiter, allowedAgents []string) gin.HandlerFunc {
+	lowered := make([]string, 0, len(allowedAgents))
+	for _, agent := range allowedAgents {
+		if agent != "" {
+			lowered = append(lowered, strings.ToLower(agent))
+		}
+	}
+
 	return func(c *gin.Context) {
-		if !limiter.Allow() {
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
-				"error": "Too many request",
+		userAgent := strings.ToLower(c.Request.UserAgent())
+
+		for _, agent := range lowered {
+			if strings.Contains(userAgent, agent) {
+				// Nothing is taken from a bucket, so there's nothing remaining to report
+				c.Header("RateLimit-Limit", strconv.Itoa(limiter.Limit()))
+				c.Next()
+				return
+			}
+		}
+
+		// Proxy headers are only respected if the request came from a trusted proxy
+		result := limiter.Allow(c.Request.Context(), c.ClientIP())
+		result.setHeaders(c)
+
+		if !result.Allowed {
+			if isAPIRequest(c) {
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+					"error": "Too many request",
+				})
+				return
+			}
+
+			c.HTML(http.StatusTooManyRequests, "rate_limited.html", gin.H{
+				"RetryAfter": max(ceilSeconds(result.RetryAfter), 1),
 			})
+			c.Abort()
 			return
 		}
 		c.Next()
 	}
 }
+
+func isAPIRequest(c *gin.Context) bool {
+	path := c.Request.URL.Path
+	return strings.HasPrefix(path, "/api/") || path == "/oembed"
+}
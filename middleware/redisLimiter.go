@@ -0,0 +1,147 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	redisLimiterPrefix = "vxinst:ratelimit:"
+	// Don't let a slow redis hold up every request
+	redisLimiterTimeout = 250 * time.Millisecond
+	// How long to stick with the local limiter after redis failed
+	redisLimiterBackoff = 5 * time.Second
+)
+
+// Token bucket kept in a hash with the amount of tokens and when they were
+// last refilled. Uses the redis clock so instances with drifting clocks still
+// agree. The key expires once the bucket would be full again, so idle clients
+// don't take up memory
+var tokenBucketScript = redis.NewScript(`
+redis.replicate_commands()
+
+local rate = tonumber(ARGV[1])
+local bucket = tonumber(ARGV[2])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or bucket
+local ts = tonumber(state[2]) or now
+
+tokens = math.min(bucket, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("EXPIRE", KEYS[1], math.ceil(bucket / rate) + 1)
+
+-- Numbers returned from lua are truncated to integers
+return {allowed, tostring(tokens)}
+`)
+
+// Rate limiter sharing its buckets between every instance connected to the
+// same redis. Falls back to a local limiter while redis is unreachable
+type RedisLimiter struct {
+	rdb      *redis.Client
+	rate     int
+	bucket   int
+	fallback *RateLimiter
+	// When to try redis again after it failed (unix nanoseconds). 0 while redis works
+	retryAt atomic.Int64
+}
+
+func NewRedisLimiter(rdb *redis.Client, rate, bucket int) *RedisLimiter {
+	limiter := &RedisLimiter{
+		rdb:      rdb,
+		rate:     rate,
+		bucket:   bucket,
+		fallback: NewRateLimiter(rate, bucket),
+	}
+	return limiter
+}
+
+func (r *RedisLimiter) Limit() int {
+	return r.bucket
+}
+
+func (r *RedisLimiter) Allow(ctx context.Context, key string) Result {
+	// While redis is down, waiting for it to time out would slow down every request.
+	// Once the backoff passes a single request checks if it's back while
+	// everyone else keeps using the local limiter
+	if retryAt := r.retryAt.Load(); retryAt != 0 {
+		now := time.Now()
+		if now.UnixNano() < retryAt || !r.retryAt.CompareAndSwap(retryAt, now.Add(redisLimiterBackoff).UnixNano()) {
+			return r.fallback.Allow(ctx, key)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisLimiterTimeout)
+	defer cancel()
+
+	allowed, tokens, err := r.take(ctx, key)
+	if err != nil {
+		if r.retryAt.Swap(time.Now().Add(redisLimiterBackoff).UnixNano()) == 0 {
+			slog.Warn("Redis rate limiter unavailable, falling back to local limiter", slog.Any("err", err))
+			sentry.CaptureException(err)
+		}
+
+		return r.fallback.Allow(ctx, key)
+	}
+
+	if r.retryAt.Swap(0) != 0 {
+		slog.Info("Redis rate limiter is available again")
+	}
+
+	return newResult(allowed, tokens, float64(r.bucket), float64(r.rate))
+}
+
+func (r *RedisLimiter) take(ctx context.Context, key string) (bool, float64, error) {
+	res, err := tokenBucketScript.Run(ctx, r.rdb, []string{redisLimiterPrefix + key}, strconv.Itoa(r.rate), strconv.Itoa(r.bucket)).Slice()
+	if err != nil {
+		return false, 0, err
+	}
+
+	if len(res) != 2 {
+		return false, 0, fmt.Errorf("unexpected token bucket reply: %v", res)
+	}
+
+	allowed, _ := res[0].(int64)
+	remaining, _ := res[1].(string)
+
+	tokens, err := strconv.ParseFloat(remaining, 64)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to parse remaining tokens: %v", err)
+	}
+
+	return allowed == 1, tokens, nil
+}
@@ -0,0 +1,313 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package scraper
+
+import (
+	"bitwise7/vxinst/flags"
+	"bitwise7/vxinst/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// Keeps track of every known scraper and which of them are enabled (and in
+// what order they should be tried)
+type Registry struct {
+	mutex    sync.RWMutex
+	scrapers map[string]Scraper
+	enabled  []Scraper
+	race     bool
+	wins     map[string]int
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		scrapers: map[string]Scraper{},
+		wins:     map[string]int{},
+	}
+}
+
+// Creates a registry with the builtin scrapers registered and the ones picked
+// with --scraping-methods enabled
+func NewDefaultRegistry() (*Registry, error) {
+	r := NewRegistry()
+
+	for _, s := range Builtin() {
+		r.Register(s)
+	}
+
+	if err := r.Enable(*flags.ScrapingMethods...); err != nil {
+		return nil, err
+	}
+
+	r.SetRace(*flags.ScrapingRace)
+
+	return r, nil
+}
+
+// Makes a scraper available. Registering a scraper with a name that's
+// already taken replaces the old one
+func (r *Registry) Register(s Scraper) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.scrapers[s.Name()] = s
+
+	for i, e := range r.enabled {
+		if e.Name() == s.Name() {
+			r.enabled[i] = s
+		}
+	}
+}
+
+// Replaces the enabled scrapers with the given ones in the given order.
+// Unknown scrapers and ones that need a cookie when none is configured
+// are skipped. Fails if nothing could be enabled
+func (r *Registry) Enable(names ...string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	enabled := make([]Scraper, 0, len(names))
+
+	for _, name := range names {
+		s, ok := r.scrapers[name]
+		if !ok {
+			slog.Warn("Unknown scraping method, skipping", slog.String("method", name))
+			continue
+		}
+
+		if s.Capabilities().Has(NeedsCookie) && *flags.InstagramCookie == "" {
+			slog.Warn("Scraping method needs an instagram cookie, skipping", slog.String("method", name))
+			continue
+		}
+
+		slog.Debug("Enabled scraping method", slog.String("method", name), slog.String("capabilities", s.Capabilities().String()))
+		enabled = append(enabled, s)
+	}
+
+	if len(enabled) == 0 {
+		return fmt.Errorf("no usable scraping methods in %v", names)
+	}
+
+	var combined Capability
+	for _, s := range enabled {
+		combined |= s.Capabilities()
+	}
+
+	if !combined.Has(SupportsCarousel) {
+		slog.Warn("No enabled scraping method supports carousels. Only the first slide of carousel posts will be embedded")
+	}
+
+	if !combined.Has(SupportsAgeRestricted) {
+		slog.Warn("No enabled scraping method supports age-restricted posts. They won't be embedded")
+	}
+
+	r.enabled = enabled
+	return nil
+}
+
+// Stops a scraper from being used. It stays registered so it can be enabled again
+func (r *Registry) Disable(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, s := range r.enabled {
+		if s.Name() == name {
+			r.enabled = append(r.enabled[:i:i], r.enabled[i+1:]...)
+			return
+		}
+	}
+}
+
+// Sets if enabled scrapers should run concurrently with the first one to
+// find the post winning, instead of being tried one after another
+func (r *Registry) SetRace(race bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.race = race
+}
+
+// Reports if enabled scrapers run concurrently
+func (r *Registry) Race() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.race
+}
+
+// Returns how many times each scraper found a post first. Useful for picking
+// the order of scraping methods
+func (r *Registry) Wins() map[string]int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	wins := make(map[string]int, len(r.wins))
+	for name, count := range r.wins {
+		wins[name] = count
+	}
+
+	return wins
+}
+
+func (r *Registry) Get(name string) (Scraper, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	s, ok := r.scrapers[name]
+	return s, ok
+}
+
+// Returns the enabled scrapers in the order they're tried
+func (r *Registry) Enabled() []Scraper {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return append([]Scraper(nil), r.enabled...)
+}
+
+// Scrapes the post with the enabled scrapers, either one after another or all
+// at once depending on [Registry.SetRace]. Returns every [Failure] joined
+// together if none of them found the post. The error matches [ErrNoData] if
+// at least one scraper reported the post as missing
+func (r *Registry) Scrape(ctx context.Context, postId string) (*utils.HtmlData, error) {
+	r.mutex.RLock()
+	race := r.race
+	r.mutex.RUnlock()
+
+	scrapers := r.Enabled()
+
+	if race && len(scrapers) > 1 {
+		return r.scrapeRace(ctx, postId, scrapers)
+	}
+
+	return r.scrapeSequential(ctx, postId, scrapers)
+}
+
+func (r *Registry) scrapeSequential(ctx context.Context, postId string, scrapers []Scraper) (*utils.HtmlData, error) {
+	errs := []error{}
+	start := time.Now()
+
+	for _, s := range scrapers {
+		slog.Debug("Trying method", slog.String("method", s.Name()), slog.String("id", postId))
+
+		data, err := scrape(ctx, s, postId)
+		if err == nil {
+			r.recordWin(s.Name(), postId, data, time.Since(start))
+			return data, nil
+		}
+
+		failure := &Failure{Scraper: s.Name(), Err: err}
+		reportFailure(postId, failure)
+		errs = append(errs, failure)
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil, ErrNoData
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// Runs every scraper at once. The first one to return complete data wins and
+// the others are cancelled
+func (r *Registry) scrapeRace(ctx context.Context, postId string, scrapers []Scraper) (*utils.HtmlData, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		scraper string
+		data    *utils.HtmlData
+		err     error
+	}
+
+	// Buffered so the losers don't block forever after we stop listening
+	results := make(chan result, len(scrapers))
+	start := time.Now()
+
+	for _, s := range scrapers {
+		slog.Debug("Racing method", slog.String("method", s.Name()), slog.String("id", postId))
+
+		go func() {
+			data, err := scrape(ctx, s, postId)
+			results <- result{s.Name(), data, err}
+		}()
+	}
+
+	errs := []error{}
+
+	for range scrapers {
+		res := <-results
+
+		if res.err == nil {
+			r.recordWin(res.scraper, postId, res.data, time.Since(start))
+			return res.data, nil
+		}
+
+		failure := &Failure{Scraper: res.scraper, Err: res.err}
+		reportFailure(postId, failure)
+		errs = append(errs, failure)
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// Runs the scraper and makes sure whatever it returned is actually usable
+func scrape(ctx context.Context, s Scraper, postId string) (*utils.HtmlData, error) {
+	data, err := s.Scrape(ctx, postId)
+	if err != nil {
+		return nil, err
+	}
+
+	if data.Slide(1) == nil {
+		return nil, ErrIncomplete
+	}
+
+	return data, nil
+}
+
+func (r *Registry) recordWin(scraper, postId string, data *utils.HtmlData, took time.Duration) {
+	data.ScrapedBy = scraper
+
+	r.mutex.Lock()
+	r.wins[scraper]++
+	r.mutex.Unlock()
+
+	slog.Debug("Found some data", slog.String("method", scraper), slog.String("id", postId), slog.Duration("took", took))
+}
+
+func reportFailure(postId string, f *Failure) {
+	switch {
+	case errors.Is(f.Err, ErrNoData), errors.Is(f.Err, ErrIncomplete):
+		slog.Debug("Method didn't get any data", slog.String("method", f.Scraper), slog.String("id", postId))
+	case errors.Is(f.Err, context.Canceled), errors.Is(f.Err, context.DeadlineExceeded):
+		slog.Debug("Method was cancelled", slog.String("method", f.Scraper), slog.String("id", postId))
+	default:
+		slog.Error("Method failed", slog.String("method", f.Scraper), slog.String("id", postId), slog.Any("err", f.Err))
+		sentry.CaptureException(f)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package scraper
+
+import (
+	"bitwise7/vxinst/utils"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Describes what a scraper can do or needs to work
+type Capability uint8
+
+const (
+	// The scraper can't do anything without an instagram cookie
+	NeedsCookie Capability = 1 << iota
+	// The scraper returns every slide of carousel posts
+	SupportsCarousel
+	// The scraper can fetch age-restricted posts
+	SupportsAgeRestricted
+)
+
+var capabilityNames = []string{"needs cookie", "supports carousel", "supports age-restricted"}
+
+func (c Capability) Has(other Capability) bool {
+	return c&other == other
+}
+
+func (c Capability) String() string {
+	names := []string{}
+
+	for i, name := range capabilityNames {
+		if c.Has(1 << i) {
+			names = append(names, name)
+		}
+	}
+
+	return strings.Join(names, ", ")
+}
+
+var (
+	// Returned by scrapers that finished without errors but didn't find the post
+	ErrNoData = errors.New("no data found")
+	// Returned when a scraper found the post but not a single image or video in it
+	ErrIncomplete = errors.New("no media found in post data")
+)
+
+// A single way of getting post data out of instagram
+type Scraper interface {
+	// Name used to enable the scraper with --scraping-methods
+	Name() string
+	Capabilities() Capability
+	// Returns [ErrNoData] if the post couldn't be found
+	Scrape(ctx context.Context, postId string) (*utils.HtmlData, error)
+}
+
+// Error returned when a scraper fails. Every failure goes through this so they're
+// reported the same way no matter which handler triggered the scraping
+type Failure struct {
+	Scraper string
+	Err     error
+}
+
+func (f *Failure) Error() string {
+	return fmt.Sprintf("%s: %v", f.Scraper, f.Err)
+}
+
+func (f *Failure) Unwrap() error {
+	return f.Err
+}
+
+// Adapts a plain function to the [Scraper] interface
+type funcScraper struct {
+	name         string
+	capabilities Capability
+	fn           func(ctx context.Context, postId string) (*utils.HtmlData, error)
+}
+
+func (s *funcScraper) Name() string             { return s.name }
+func (s *funcScraper) Capabilities() Capability { return s.capabilities }
+
+func (s *funcScraper) Scrape(ctx context.Context, postId string) (*utils.HtmlData, error) {
+	data, err := s.fn(ctx, postId)
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		return nil, ErrNoData
+	}
+
+	return data, nil
+}
+
+// Creates a scraper from a function returning nil data when the post can't be found
+func New(name string, capabilities Capability, fn func(ctx context.Context, postId string) (*utils.HtmlData, error)) Scraper {
+	return &funcScraper{
+		name:         name,
+		capabilities: capabilities,
+		fn:           fn,
+	}
+}
+
+// Scrapers shipped with vxinst
+func Builtin() []Scraper {
+	return []Scraper{
+		New("html", SupportsCarousel, utils.ScrapeFromHTML),
+		New("graphql", SupportsCarousel, utils.ScrapeFromGQL),
+		New("api", NeedsCookie|SupportsCarousel|SupportsAgeRestricted, utils.ScrapeFromAPI),
+	}
+}
@@ -0,0 +1,137 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package shortcode
+
+import (
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Shortcodes are media IDs encoded with the URL safe base64 alphabet
+const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+const (
+	// Characters encoding the media ID. Private posts have longer shortcodes
+	// with extra characters after these
+	idLength  = 11
+	minLength = 5
+	maxLength = 64
+)
+
+var (
+	ErrInvalid  = errors.New("invalid shortcode")
+	ErrOverflow = errors.New("shortcode doesn't fit in a media ID")
+)
+
+// Reports if s only contains characters from the shortcode alphabet and has a sane length
+func Valid(s string) bool {
+	if len(s) < minLength || len(s) > maxLength {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(alphabet, s[i]) == -1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Converts a shortcode to the numeric media ID the API uses
+func ToMediaID(s string) (uint64, error) {
+	if !Valid(s) {
+		return 0, ErrInvalid
+	}
+
+	s = s[:min(len(s), idLength)]
+
+	var id uint64
+
+	for i := 0; i < len(s); i++ {
+		hi, lo := bits.Mul64(id, 64)
+		if hi != 0 {
+			return 0, ErrOverflow
+		}
+
+		id = lo + uint64(strings.IndexByte(alphabet, s[i]))
+	}
+
+	return id, nil
+}
+
+// Converts a numeric media ID to its shortcode
+func FromMediaID(id uint64) string {
+	if id == 0 {
+		return string(alphabet[0])
+	}
+
+	var buf [idLength]byte
+	i := len(buf)
+
+	for id > 0 {
+		i--
+		buf[i] = alphabet[id%64]
+		id /= 64
+	}
+
+	return string(buf[i:])
+}
+
+// Parses a media ID as returned by the API. Those sometimes have the ID of
+// the author appended after an underscore (<media id>_<user id>)
+func ParseMediaID(s string) (uint64, error) {
+	s, _, _ = strings.Cut(s, "_")
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// Turns either a shortcode or a numeric media ID into a shortcode
+func Parse(id string) (string, bool) {
+	if id == "" {
+		return "", false
+	}
+
+	if isNumeric(id) {
+		mediaId, err := ParseMediaID(id)
+		if err != nil {
+			return "", false
+		}
+
+		return FromMediaID(mediaId), true
+	}
+
+	return id, Valid(id)
+}
+
+// Media IDs are all digits, optionally followed by _<user id>
+func isNumeric(s string) bool {
+	head, tail, _ := strings.Cut(s, "_")
+
+	for _, part := range []string{head, tail} {
+		for i := 0; i < len(part); i++ {
+			if part[i] < '0' || part[i] > '9' {
+				return false
+			}
+		}
+	}
+
+	// Shortcodes are never this long and only digits
+	return len(head) > idLength
+}
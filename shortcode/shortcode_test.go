@@ -0,0 +1,111 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package shortcode
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestKnownPair(t *testing.T) {
+	const code = "ybyPRoQWzX"
+	const id uint64 = 908540701891980503
+
+	got, err := ToMediaID(code)
+	if err != nil || got != id {
+		t.Errorf("ToMediaID(%q) = %d, %v; want %d", code, got, err, id)
+	}
+
+	if got := FromMediaID(id); got != code {
+		t.Errorf("FromMediaID(%d) = %q; want %q", id, got, code)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	codes := []string{"DGnYkxfSDvL", "C8m1Qv-Ox_Z", "BQ0eAlwhDrw", "PAAAAAAAAAA", "P__________"}
+
+	for _, code := range codes {
+		id, err := ToMediaID(code)
+		if err != nil {
+			t.Errorf("ToMediaID(%q) failed: %v", code, err)
+			continue
+		}
+
+		if got := FromMediaID(id); got != code {
+			t.Errorf("FromMediaID(ToMediaID(%q)) = %q", code, got)
+		}
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	// 16 * 64^10 is exactly 2^64
+	if _, err := ToMediaID("QAAAAAAAAAA"); !errors.Is(err, ErrOverflow) {
+		t.Errorf("ToMediaID(QAAAAAAAAAA) = %v; want %v", err, ErrOverflow)
+	}
+
+	id, err := ToMediaID("P__________")
+	if err != nil || id != math.MaxUint64 {
+		t.Errorf("ToMediaID(P__________) = %d, %v; want %d", id, err, uint64(math.MaxUint64))
+	}
+
+	if _, err := ToMediaID("not a code!"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("ToMediaID(not a code!) = %v; want %v", err, ErrInvalid)
+	}
+}
+
+func TestParseMediaID(t *testing.T) {
+	tests := map[string]uint64{
+		"908540701891980503":          908540701891980503,
+		"908540701891980503_1639186":  908540701891980503,
+		"3577936509124754379_1234567": 3577936509124754379,
+	}
+
+	for raw, want := range tests {
+		if got, err := ParseMediaID(raw); err != nil || got != want {
+			t.Errorf("ParseMediaID(%q) = %d, %v; want %d", raw, got, err, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+		ok   bool
+	}{
+		{"DGnYkxfSDvL", "DGnYkxfSDvL", true},
+		{"908540701891980503", "ybyPRoQWzX", true},
+		{"908540701891980503_1639186", "ybyPRoQWzX", true},
+		// Up to 11 digits is a shortcode that happens to be all digits
+		{"12345678901", "12345678901", true},
+		{"123456789012", FromMediaID(123456789012), true},
+		{"12345", "12345", true},
+		{"1234", "1234", false},
+		{"", "", false},
+		{"DGnYkx/SDvL", "DGnYkx/SDvL", false},
+		{"99999999999999999999999", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := Parse(tt.id)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Parse(%q) = %q, %v; want %q, %v", tt.id, got, ok, tt.want, tt.ok)
+		}
+	}
+}
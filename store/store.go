@@ -0,0 +1,303 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package store
+
+import (
+	"bitwise7/vxinst/flags"
+	"bitwise7/vxinst/scraper"
+	"bitwise7/vxinst/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// How long a coalesced scrape may take
+const scrapeTimeout = 20 * time.Second
+
+// Returned for posts that recently failed to scrape. Matches [scraper.ErrNoData]
+type CachedFailure struct {
+	Reason    string
+	ExpiresAt int64
+}
+
+func (f *CachedFailure) Error() string {
+	return fmt.Sprintf("post recently failed to scrape (%s), retrying after %s", f.Reason, time.Unix(f.ExpiresAt, 0).Format(time.RFC3339))
+}
+
+func (f *CachedFailure) Unwrap() error {
+	return scraper.ErrNoData
+}
+
+// Sits between the handlers and the database, scraping posts that aren't
+// stored yet. Concurrent requests for the same post share a single scrape
+type Store struct {
+	db         *gorm.DB
+	scrapers   *scraper.Registry
+	group      singleflight.Group
+	shareGroup singleflight.Group
+}
+
+func New(db *gorm.DB, scrapers *scraper.Registry) *Store {
+	return &Store{
+		db:       db,
+		scrapers: scrapers,
+	}
+}
+
+// Returns the scrapers used to fetch posts that aren't stored
+func (s *Store) Scrapers() *scraper.Registry {
+	return s.scrapers
+}
+
+// Returns the post from the database or scrapes it if it isn't stored yet, the
+// stored record expired or its media links stopped working. Posts with media
+// links about to expire are served as they are while being refreshed in the
+// background. Returns [scraper.ErrNoData] if the post couldn't be found and a
+// [CachedFailure] if it couldn't be found recently. The returned data may be
+// shared with other callers and must not be modified
+func (s *Store) Get(ctx context.Context, postId string) (*utils.HtmlData, error) {
+	now := time.Now()
+
+	if data, ok := s.lookup(postId); ok {
+		switch {
+		case data.MediaExpired(now):
+			slog.Debug("Media links of record expired. Fetching new data", slog.String("id", postId))
+		case data.MediaStale(now, staleWindow()):
+			// A recent refresh failed, so instagram is unlikely to answer now either
+			if now.Unix() < data.RefreshAfter {
+				return usable(data)
+			}
+
+			slog.Debug("Media links of record expire soon. Refreshing in the background", slog.String("id", postId))
+			s.fetch(postId)
+			return usable(data)
+		default:
+			slog.Debug("Found record in database", slog.String("id", postId))
+			return usable(data)
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-s.fetch(postId):
+		if res.Shared {
+			slog.Debug("Shared scraping result with other requests", slog.String("id", postId))
+		}
+
+		if res.Err != nil {
+			return nil, res.Err
+		}
+
+		return usable(res.Val.(*utils.HtmlData))
+	}
+}
+
+// Scrapes and saves the post unless someone else is already doing that. The
+// scrape is detached from whoever started it so one impatient client can't
+// cancel it for everyone else
+func (s *Store) fetch(postId string) <-chan singleflight.Result {
+	return s.group.DoChan(postId, func() (any, error) {
+		// Someone may have refreshed the post between our lookup and getting here
+		old, ok := s.lookup(postId)
+		if ok && !old.NotFound && !old.MediaStale(time.Now(), staleWindow()) {
+			return old, nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+		defer cancel()
+
+		data, err := s.scrapers.Scrape(ctx, postId)
+		if err != nil {
+			// Stale data is still better than nothing as long as the links work
+			if ok && !old.NotFound && !old.MediaExpired(time.Now()) {
+				slog.Warn("Failed to refresh post, keeping stale data", slog.String("id", postId))
+				s.delayRefresh(postId)
+				return old, nil
+			}
+
+			s.saveFailure(postId, err)
+			return nil, err
+		}
+
+		s.save(postId, data)
+
+		return data, nil
+	})
+}
+
+// Returns the shortcode of the post a share link points at. Resolved links
+// are remembered for --memory-lifetime days since they never change
+func (s *Store) ResolveShare(ctx context.Context, kind, shareId string) (string, error) {
+	var link utils.ShareLink
+
+	err := s.db.
+		Model(&utils.ShareLink{}).
+		Where("id = ? AND expires_at > ?", kind+"/"+shareId, time.Now().Unix()).
+		First(&link).
+		Error
+	if err == nil {
+		slog.Debug("Found share link in database", slog.String("id", shareId), slog.String("shortcode", link.Shortcode))
+		return link.Shortcode, nil
+	}
+
+	if err != gorm.ErrRecordNotFound {
+		slog.Error("Failed to read share link from database", slog.Any("err", err))
+	}
+
+	ch := s.shareGroup.DoChan(kind+"/"+shareId, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+		defer cancel()
+
+		postId, err := utils.ResolveShare(ctx, kind, shareId)
+		if err != nil {
+			return "", err
+		}
+
+		link := &utils.ShareLink{
+			ID:        kind + "/" + shareId,
+			Shortcode: postId,
+			ExpiresAt: time.Now().Add(time.Hour * time.Duration(24*(*flags.MemoryLifetime))).Unix(),
+		}
+
+		if err := s.db.Model(&utils.ShareLink{}).Clauses(clause.OnConflict{UpdateAll: true}).Create(link).Error; err != nil {
+			sentry.CaptureException(err)
+			slog.Error("Failed to save share link to database", slog.Any("err", err))
+		}
+
+		return postId, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return "", res.Err
+		}
+
+		return res.Val.(string), nil
+	}
+}
+
+func (s *Store) lookup(postId string) (*utils.HtmlData, bool) {
+	var data *utils.HtmlData
+
+	err := s.db.
+		Model(&utils.HtmlData{}).
+		Where("shortcode = ? AND expires_at > ?", postId, time.Now().Unix()).
+		First(&data).
+		Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			slog.Debug("No record found. Fetching new data", slog.String("id", postId))
+		} else {
+			slog.Error("Failed to read cache from database", slog.Any("err", err))
+		}
+
+		return nil, false
+	}
+
+	return data, true
+}
+
+// Stores the scraped data for --memory-lifetime days
+func (s *Store) save(postId string, data *utils.HtmlData) {
+	slog.Debug("Creating new record in database", slog.String("id", postId))
+
+	// Always key by what was requested so the next lookup finds the record
+	data.Shortcode = postId
+	data.ExpiresAt = time.Now().Add(time.Hour * time.Duration(24*(*flags.MemoryLifetime))).Unix()
+	data.MediaExpiresAt = data.EarliestMediaExpiry()
+
+	s.upsert(data)
+}
+
+// Remembers that scraping the post failed for --negative-lifetime minutes so
+// we don't keep hammering instagram for it, but still retry soon in case the
+// failure was only temporary
+func (s *Store) saveFailure(postId string, err error) {
+	slog.Debug("Remembering failed post", slog.String("id", postId), slog.Any("err", err))
+
+	record := &utils.HtmlData{
+		Shortcode:     postId,
+		NotFound:      true,
+		FailureReason: failureReason(err),
+		ExpiresAt:     time.Now().Add(time.Minute * time.Duration(*flags.NegativeLifetime)).Unix(),
+	}
+
+	s.upsert(record)
+}
+
+// Stops stale records from being refreshed for --negative-lifetime minutes
+// after a refresh failed. Otherwise every request in the stale window would
+// start another scrape while instagram is down
+func (s *Store) delayRefresh(postId string) {
+	refreshAfter := time.Now().Add(time.Minute * time.Duration(*flags.NegativeLifetime)).Unix()
+
+	if err := s.db.Model(&utils.HtmlData{}).Where("shortcode = ?", postId).Update("refresh_after", refreshAfter).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error("Failed to delay refreshing record", slog.Any("err", err))
+	}
+}
+
+func (s *Store) upsert(record *utils.HtmlData) {
+	// Replace expired records that weren't cleaned up yet
+	if err := s.db.Model(&utils.HtmlData{}).Clauses(clause.OnConflict{UpdateAll: true}).Create(record).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error("Failed to save record to memory database", slog.Any("err", err))
+	}
+}
+
+func staleWindow() time.Duration {
+	return time.Minute * time.Duration(*flags.StaleWindow)
+}
+
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timed out"
+	case errors.Is(err, scraper.ErrNoData), errors.Is(err, scraper.ErrIncomplete):
+		return "not found"
+	default:
+		return "scraping failed"
+	}
+}
+
+func usable(data *utils.HtmlData) (*utils.HtmlData, error) {
+	if data.NotFound {
+		return nil, &CachedFailure{
+			Reason:    data.FailureReason,
+			ExpiresAt: data.ExpiresAt,
+		}
+	}
+
+	// Records saved before failures got their own state are empty instead
+	if data.Slide(1) == nil {
+		return nil, scraper.ErrNoData
+	}
+
+	return data, nil
+}
@@ -0,0 +1,54 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package utils
+
+import (
+	"bitwise7/vxinst/flags"
+	"strings"
+	"sync"
+)
+
+var botAgents = sync.OnceValue(func() []string {
+	agents := make([]string, 0, len(*flags.BotAgents))
+	for _, agent := range *flags.BotAgents {
+		if agent != "" {
+			agents = append(agents, strings.ToLower(agent))
+		}
+	}
+
+	return agents
+})
+
+// Reports if the user agent belongs to a crawler or a chat client unfurling a
+// link rather than a person using a browser. Anything that doesn't even claim
+// to be a browser (curl, empty agents) counts as a bot too
+func IsBot(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+
+	if !strings.Contains(userAgent, "mozilla/") {
+		return true
+	}
+
+	for _, agent := range botAgents() {
+		if strings.Contains(userAgent, agent) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,80 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	hashtagRegex = regexp.MustCompile(`#[\p{L}\p{N}_]+`)
+	// Usernames can't end with a period, so a mention at the end of a sentence
+	// doesn't take the period with it
+	mentionRegex = regexp.MustCompile(`@[A-Za-z0-9_](?:[A-Za-z0-9_.]*[A-Za-z0-9_])?`)
+)
+
+// Returns the unique hashtags in a caption in the order they first appear
+func Hashtags(caption string) []string {
+	return uniqueMatches(hashtagRegex, caption)
+}
+
+// Returns the unique users mentioned in a caption in the order they first appear
+func Mentions(caption string) []string {
+	return uniqueMatches(mentionRegex, caption)
+}
+
+func uniqueMatches(re *regexp.Regexp, s string) []string {
+	seen := map[string]bool{}
+	matches := []string{}
+
+	for _, match := range re.FindAllString(s, -1) {
+		key := strings.ToLower(match)
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		matches = append(matches, match)
+	}
+
+	return matches
+}
+
+// Cuts s down to at most limit characters, preferring to cut at a space and
+// ending with an ellipsis if anything was cut off
+func Truncate(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+
+	if limit <= 0 {
+		return ""
+	}
+
+	runes := []rune(s)
+	cut := string(runes[:limit-1])
+
+	// Don't cut words in half unless that would throw away most of the text
+	if i := strings.LastIndexAny(cut, " \n"); i > len(cut)/2 {
+		cut = cut[:i]
+	}
+
+	return strings.TrimRight(cut, " \n") + "…"
+}
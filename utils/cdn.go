@@ -0,0 +1,88 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package utils
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Returns when an instagram CDN URL stops working. The CDN signs every URL with
+// an expiry time stored as a hex encoded unix timestamp in the oe parameter.
+// Returns 0 if the URL doesn't expire (or we can't tell)
+func CdnExpiry(rawURL string) int64 {
+	if rawURL == "" {
+		return 0
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return 0
+	}
+
+	oe := u.Query().Get("oe")
+	if oe == "" {
+		return 0
+	}
+
+	expiry, err := strconv.ParseInt(oe, 16, 64)
+	if err != nil || expiry <= 0 {
+		return 0
+	}
+
+	return expiry
+}
+
+// Returns when the first media URL of the post expires. Returns 0 if none of them do
+func (h *HtmlData) EarliestMediaExpiry() int64 {
+	urls := []string{h.ThumbnailURL}
+
+	if h.Video != nil {
+		urls = append(urls, h.Video.URL)
+	}
+
+	for _, item := range h.Media {
+		urls = append(urls, item.ThumbnailURL)
+
+		if item.Video != nil {
+			urls = append(urls, item.Video.URL)
+		}
+	}
+
+	var earliest int64
+
+	for _, u := range urls {
+		if expiry := CdnExpiry(u); expiry != 0 && (earliest == 0 || expiry < earliest) {
+			earliest = expiry
+		}
+	}
+
+	return earliest
+}
+
+// Reports if the media URLs of the post already stopped working
+func (h *HtmlData) MediaExpired(now time.Time) bool {
+	return h.MediaExpiresAt != 0 && now.Unix() >= h.MediaExpiresAt
+}
+
+// Reports if the media URLs of the post stop working within the given window
+// and should be refreshed
+func (h *HtmlData) MediaStale(now time.Time, window time.Duration) bool {
+	return h.MediaExpiresAt != 0 && now.Add(window).Unix() >= h.MediaExpiresAt
+}
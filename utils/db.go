@@ -28,7 +28,7 @@ func InitDb() (*gorm.DB, error) {
 		return nil, err
 	}
 
-	err = db.AutoMigrate(&HtmlData{})
+	err = db.AutoMigrate(&HtmlData{}, &ShareLink{})
 	if err != nil {
 		return nil, err
 	}
@@ -24,8 +24,8 @@ import (
 )
 
 const (
-	context    = `"contextJSON":`
-	contextEnd = `,\"gql_data`
+	contextStart = `"contextJSON":`
+	contextEnd   = `,\"gql_data`
 )
 
 // The only reason this exists is to get rid of the context key that's adding unnecessary
@@ -33,33 +33,79 @@ const (
 type rawHtmlData struct {
 	Context struct {
 		Media struct {
-			Dimensions struct {
-				Height, Width int
-			} `json:"dimensions"`
-			ThumbnailURL   string `json:"display_url"`
-			IsVideo        bool   `json:"is_video"`
-			VideoURL       string `json:"video_url"`
+			rawMediaNode
 			VideoViewCount int    `json:"video_view_count"`
 			Shortcode      string `json:"shortcode"`
+			Sidecar        struct {
+				Edges []struct {
+					Node rawMediaNode `json:"node"`
+				} `json:"edges"`
+			} `json:"edge_sidecar_to_children"`
 		} `json:"media"`
-		Permalink        string `json:"media_permalink"`
-		MusicAttribution struct {
-			ArtistName            string `json:"artist_name"`
-			SongName              string `json:"song_name"`
-			UsesOriginalAudio     bool   `json:"uses_original_audio"`
-			ShouldMuteAudio       bool   `json:"should_mute_audio"`
-			ShouldMuteAudioReason string `json:"should_mute_audio_reason"`
-			AudioID               string `json:"audio_id"`
-		} `json:"clips_music_attribution_info"`
-		Caption       string `json:"caption"`
-		CommentsCount int    `json:"comments_count"`
-		LikesCount    int    `json:"likes_count"`
-		ProfileURL    string `json:"profile_url"`
-		Username      string `json:"username"`
-		VideoViews    int    `json:"video_views"`
+		Permalink        string              `json:"media_permalink"`
+		MusicAttribution rawMusicAttribution `json:"clips_music_attribution_info"`
+		Caption          string              `json:"caption"`
+		CommentsCount    int                 `json:"comments_count"`
+		LikesCount       int                 `json:"likes_count"`
+		ProfileURL       string              `json:"profile_url"`
+		Username         string              `json:"username"`
+		VideoViews       int                 `json:"video_views"`
 	} `json:"context"`
 }
 
+type rawMusicAttribution struct {
+	ArtistName            string `json:"artist_name"`
+	SongName              string `json:"song_name"`
+	UsesOriginalAudio     bool   `json:"uses_original_audio"`
+	ShouldMuteAudio       bool   `json:"should_mute_audio"`
+	ShouldMuteAudioReason string `json:"should_mute_audio_reason"`
+	AudioID               string `json:"audio_id"`
+}
+
+// Returns nil if the post doesn't have any music attached
+func (m *rawMusicAttribution) toMusicData() *MusicData {
+	if m.ArtistName == "" && m.SongName == "" {
+		return nil
+	}
+
+	return &MusicData{
+		ArtistName:        m.ArtistName,
+		SongName:          m.SongName,
+		UsesOriginalAudio: m.UsesOriginalAudio,
+		Muted:             m.ShouldMuteAudio,
+		MuteReason:        m.ShouldMuteAudioReason,
+		AudioID:           m.AudioID,
+	}
+}
+
+// Fields shared between the post itself and every child of a carousel
+type rawMediaNode struct {
+	Dimensions struct {
+		Height, Width int
+	} `json:"dimensions"`
+	ThumbnailURL string `json:"display_url"`
+	IsVideo      bool   `json:"is_video"`
+	VideoURL     string `json:"video_url"`
+}
+
+func (n *rawMediaNode) toMediaItem() MediaItem {
+	item := MediaItem{
+		ThumbnailURL: n.ThumbnailURL,
+		Height:       n.Dimensions.Height,
+		Width:        n.Dimensions.Width,
+	}
+
+	if n.IsVideo && n.VideoURL != "" {
+		item.Video = &VideoData{
+			URL:    n.VideoURL,
+			Height: n.Dimensions.Height,
+			Width:  n.Dimensions.Width,
+		}
+	}
+
+	return item
+}
+
 type HtmlData struct {
 	Shortcode    string      `json:"shortcode" gorm:"primaryKey;index"`
 	Permalink    string      `json:"permalink"`
@@ -71,7 +117,18 @@ type HtmlData struct {
 	Comments     int         `json:"comments"`
 	Video        *VideoData  `json:"video,omitempty" gorm:"serializer:json"`
 	Author       *AuthorData `json:"author" gorm:"serializer:json"`
+	Media        []MediaItem `json:"media,omitempty" gorm:"serializer:json"`
+	Music        *MusicData  `json:"music,omitempty" gorm:"serializer:json"`
+	ScrapedBy    string      `json:"scraped_by,omitempty"`
 	ExpiresAt    int64       `json:"expires_at"`
+	// When the first media URL stops working. 0 if the URLs don't expire
+	MediaExpiresAt int64 `json:"media_expires_at,omitempty"`
+	// Set when refreshing stale media failed. No refresh is attempted before then
+	RefreshAfter int64 `json:"-"`
+	// Set for records remembering that scraping the post failed. They expire
+	// much sooner than normal records so we retry in a few minutes
+	NotFound      bool   `json:"-"`
+	FailureReason string `json:"-"`
 }
 
 func (h *HtmlData) CheckNilField(key string) (any, bool) {
@@ -89,10 +146,54 @@ func (h *HtmlData) CheckNilField(key string) (any, bool) {
 	return f.Interface(), true
 }
 
+// Returns the slide at the given 1-based index. Indexes out of range fall back
+// to the first slide. Returns nil if the post has no media at all
+func (h *HtmlData) Slide(index int) *MediaItem {
+	media := h.Media
+
+	// Records saved before carousels were supported only have the top level fields
+	if len(media) == 0 {
+		if h.Video == nil && h.ThumbnailURL == "" {
+			return nil
+		}
+
+		media = []MediaItem{{
+			ThumbnailURL: h.ThumbnailURL,
+			Video:        h.Video,
+		}}
+	}
+
+	if index < 1 || index > len(media) {
+		index = 1
+	}
+
+	return &media[index-1]
+}
+
+// A single slide of a post. Posts that aren't carousels have exactly one
+type MediaItem struct {
+	ThumbnailURL string     `json:"thumbnail_url"`
+	Height       int        `json:"height"`
+	Width        int        `json:"width"`
+	Video        *VideoData `json:"video,omitempty"`
+}
+
 type VideoData struct {
 	URL    string `json:"url"`
 	Height int    `json:"height"`
 	Width  int    `json:"width"`
+	// Nil if the scraping method doesn't know whether the video has audio
+	HasAudio *bool `json:"has_audio,omitempty"`
+}
+
+// Song used in a reel
+type MusicData struct {
+	ArtistName        string `json:"artist_name"`
+	SongName          string `json:"song_name"`
+	UsesOriginalAudio bool   `json:"uses_original_audio"`
+	Muted             bool   `json:"muted"`
+	MuteReason        string `json:"mute_reason,omitempty"`
+	AudioID           string `json:"audio_id,omitempty"`
 }
 
 type AuthorData struct {
@@ -102,12 +203,12 @@ type AuthorData struct {
 
 // Extracts data from HTML. S is the current line being scanner with [bufio.Scanner]
 func ExtractHtmlData(s string) (*HtmlData, bool) {
-	startIdx := strings.Index(s, context)
+	startIdx := strings.Index(s, contextStart)
 	if startIdx == -1 {
 		return nil, false
 	}
 
-	s = s[startIdx+len(context)+1:]
+	s = s[startIdx+len(contextStart)+1:]
 
 	endIdx := strings.Index(s, contextEnd)
 	if endIdx == -1 {
@@ -133,6 +234,16 @@ func ExtractHtmlData(s string) (*HtmlData, bool) {
 		return nil, false
 	}
 
+	media := make([]MediaItem, 0, len(d.Context.Media.Sidecar.Edges)+1)
+
+	for _, edge := range d.Context.Media.Sidecar.Edges {
+		media = append(media, edge.Node.toMediaItem())
+	}
+
+	if len(media) == 0 {
+		media = append(media, d.Context.Media.toMediaItem())
+	}
+
 	c := &HtmlData{
 		Shortcode: d.Context.Media.Shortcode,
 		Author: &AuthorData{
@@ -146,11 +257,9 @@ func ExtractHtmlData(s string) (*HtmlData, bool) {
 		Views:        d.Context.Media.VideoViewCount,
 		Likes:        d.Context.LikesCount,
 		Comments:     d.Context.CommentsCount,
-		Video: &VideoData{
-			URL:    d.Context.Media.VideoURL,
-			Height: d.Context.Media.Dimensions.Height,
-			Width:  d.Context.Media.Dimensions.Width,
-		},
+		Video:        media[0].Video,
+		Media:        media,
+		Music:        d.Context.MusicAttribution.toMusicData(),
 	}
 
 	return c, true
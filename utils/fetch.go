@@ -19,13 +19,22 @@ package utils
 
 import (
 	"bitwise7/vxinst/flags"
+	"bitwise7/vxinst/shortcode"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	jsoniter "github.com/json-iterator/go"
 )
 
-var json = jsoniter.ConfigCompatibleWithStandardLibrary
+var (
+	json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+	// Returned when the flags required to make API requests aren't set
+	ErrBadFlag = errors.New("bad flag")
+)
 
 type MediaCandidate struct {
 	Width  int    `json:"width"`
@@ -47,9 +56,133 @@ type VideoVersion struct {
 }
 
 type Item struct {
-	ImageVersions ImageVersions  `json:"image_versions2"`
-	VideoVersions []VideoVersion `json:"video_versions"`
-	HasAudio      bool           `json:"has_audio"`
+	Code           string         `json:"code"`
+	MediaType      int            `json:"media_type"`
+	OriginalWidth  int            `json:"original_width"`
+	OriginalHeight int            `json:"original_height"`
+	ImageVersions  ImageVersions  `json:"image_versions2"`
+	VideoVersions  []VideoVersion `json:"video_versions"`
+	HasAudio       bool           `json:"has_audio"`
+	Caption        *struct {
+		Text string `json:"text"`
+	} `json:"caption"`
+	User struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	LikeCount     int    `json:"like_count"`
+	CommentCount  int    `json:"comment_count"`
+	PlayCount     int    `json:"play_count"`
+	ViewCount     int    `json:"view_count"`
+	CarouselMedia []Item `json:"carousel_media"`
+	ClipsMetadata *struct {
+		MusicInfo *struct {
+			AssetInfo struct {
+				DisplayArtist string `json:"display_artist"`
+				Title         string `json:"title"`
+				AudioAssetID  string `json:"audio_asset_id"`
+			} `json:"music_asset_info"`
+			ConsumptionInfo struct {
+				ShouldMuteAudio       bool   `json:"should_mute_audio"`
+				ShouldMuteAudioReason string `json:"should_mute_audio_reason"`
+			} `json:"music_consumption_info"`
+		} `json:"music_info"`
+		OriginalSoundInfo *struct {
+			AudioAssetID string `json:"audio_asset_id"`
+			Title        string `json:"original_audio_title"`
+			Artist       struct {
+				Username string `json:"username"`
+			} `json:"ig_artist"`
+			ShouldMuteAudio       bool   `json:"should_mute_audio"`
+			ShouldMuteAudioReason string `json:"should_mute_audio_reason"`
+		} `json:"original_sound_info"`
+	} `json:"clips_metadata"`
+}
+
+// Returns nil if the reel doesn't have any music attached
+func (i *Item) music() *MusicData {
+	if i.ClipsMetadata == nil {
+		return nil
+	}
+
+	if info := i.ClipsMetadata.MusicInfo; info != nil {
+		return (&rawMusicAttribution{
+			ArtistName:            info.AssetInfo.DisplayArtist,
+			SongName:              info.AssetInfo.Title,
+			ShouldMuteAudio:       info.ConsumptionInfo.ShouldMuteAudio,
+			ShouldMuteAudioReason: info.ConsumptionInfo.ShouldMuteAudioReason,
+			AudioID:               info.AssetInfo.AudioAssetID,
+		}).toMusicData()
+	}
+
+	if info := i.ClipsMetadata.OriginalSoundInfo; info != nil {
+		return (&rawMusicAttribution{
+			ArtistName:            info.Artist.Username,
+			SongName:              info.Title,
+			UsesOriginalAudio:     true,
+			ShouldMuteAudio:       info.ShouldMuteAudio,
+			ShouldMuteAudioReason: info.ShouldMuteAudioReason,
+			AudioID:               info.AudioAssetID,
+		}).toMusicData()
+	}
+
+	return nil
+}
+
+// Returns the largest image candidate
+func (i *Item) bestImage() *MediaCandidate {
+	var best *MediaCandidate
+
+	for idx, c := range i.ImageVersions.Candidates {
+		if best == nil || c.Width*c.Height > best.Width*best.Height {
+			best = &i.ImageVersions.Candidates[idx]
+		}
+	}
+
+	return best
+}
+
+// Returns the video version with the highest resolution, preferring higher
+// bandwidth if there are multiple with the same resolution
+func (i *Item) bestVideo() *VideoVersion {
+	var best *VideoVersion
+
+	for idx, v := range i.VideoVersions {
+		if best == nil ||
+			v.Width*v.Height > best.Width*best.Height ||
+			v.Width*v.Height == best.Width*best.Height && v.Bandwidth > best.Bandwidth {
+			best = &i.VideoVersions[idx]
+		}
+	}
+
+	return best
+}
+
+func (i *Item) toMediaItem() MediaItem {
+	item := MediaItem{
+		Height: i.OriginalHeight,
+		Width:  i.OriginalWidth,
+	}
+
+	if img := i.bestImage(); img != nil {
+		item.ThumbnailURL = img.URL
+
+		if item.Width == 0 {
+			item.Width, item.Height = img.Width, img.Height
+		}
+	}
+
+	if video := i.bestVideo(); video != nil {
+		hasAudio := i.HasAudio
+
+		item.Video = &VideoData{
+			URL:      video.URL,
+			Height:   video.Height,
+			Width:    video.Width,
+			HasAudio: &hasAudio,
+		}
+	}
+
+	return item
 }
 
 type IgResponse struct {
@@ -58,22 +191,27 @@ type IgResponse struct {
 
 // Makes a request to the API using the provided cookie to fetch post info.
 // Should only be used if scraping HTML fails
-func FetchPost(postId string) (*IgResponse, error) {
+func FetchPost(ctx context.Context, postId string) (*IgResponse, error) {
 	if *flags.InstagramCookie == "" {
-		return nil, fmt.Errorf("bad flag: noinstagram cookie provided")
+		return nil, fmt.Errorf("%w: no instagram cookie provided", ErrBadFlag)
 	}
 
 	if *flags.InstagramXIGAppID == "" {
-		return nil, fmt.Errorf("bad flag: no instagram x-ig-app-id provided")
+		return nil, fmt.Errorf("%w: no instagram x-ig-app-id provided", ErrBadFlag)
 	}
 
 	if *flags.InstagramBrowserAgent == "" {
-		return nil, fmt.Errorf("bad flag: invalid instagram browser agent provided")
+		return nil, fmt.Errorf("%w: invalid instagram browser agent provided", ErrBadFlag)
 	}
 
-	baseURL := "https://www.instagram.com/p/" + postId + "?__a=1&__d=dis"
+	mediaId, err := shortcode.ToMediaID(postId)
+	if err != nil {
+		return nil, err
+	}
 
-	req, err := http.NewRequest("GET", baseURL, nil)
+	baseURL := "https://www.instagram.com/api/v1/media/" + strconv.FormatUint(mediaId, 10) + "/info/"
+
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -104,3 +242,56 @@ func FetchPost(postId string) (*IgResponse, error) {
 
 	return &igResp, nil
 }
+
+// Fetches post data from the API and maps it to [HtmlData]. Requires a cookie
+// but works for age-restricted posts that can't be scraped any other way
+func ScrapeFromAPI(ctx context.Context, postId string) (*HtmlData, error) {
+	igResp, err := FetchPost(ctx, postId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(igResp.Items) == 0 {
+		return nil, nil
+	}
+
+	post := &igResp.Items[0]
+
+	media := make([]MediaItem, 0, len(post.CarouselMedia)+1)
+
+	for _, child := range post.CarouselMedia {
+		media = append(media, child.toMediaItem())
+	}
+
+	if len(media) == 0 {
+		media = append(media, post.toMediaItem())
+	}
+
+	caption := ""
+	if post.Caption != nil {
+		caption = post.Caption.Text
+	}
+
+	views := post.ViewCount
+	if views == 0 {
+		views = post.PlayCount
+	}
+
+	return &HtmlData{
+		Shortcode: post.Code,
+		Author: &AuthorData{
+			Username:   post.User.Username,
+			ProfileURL: "https://www.instagram.com/" + post.User.Username,
+		},
+		Permalink:    "https://www.instagram.com/p/" + post.Code + "/",
+		ThumbnailURL: media[0].ThumbnailURL,
+		IsVideo:      media[0].Video != nil,
+		Title:        caption,
+		Views:        views,
+		Likes:        post.LikeCount,
+		Comments:     post.CommentCount,
+		Video:        media[0].Video,
+		Media:        media,
+		Music:        post.music(),
+	}, nil
+}
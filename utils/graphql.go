@@ -0,0 +1,173 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package utils
+
+import (
+	"bitwise7/vxinst/flags"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	gqlEndpoint = "https://www.instagram.com/graphql/query"
+	// App ID used by the instagram web client. Only used if no app ID is configured
+	gqlWebAppID = "936619743392459"
+)
+
+type gqlResponse struct {
+	Data struct {
+		Media *struct {
+			rawMediaNode
+			Shortcode      string `json:"shortcode"`
+			VideoViewCount int    `json:"video_view_count"`
+			VideoPlayCount int    `json:"video_play_count"`
+			Owner          struct {
+				Username string `json:"username"`
+			} `json:"owner"`
+			Caption struct {
+				Edges []struct {
+					Node struct {
+						Text string `json:"text"`
+					} `json:"node"`
+				} `json:"edges"`
+			} `json:"edge_media_to_caption"`
+			Likes struct {
+				Count int `json:"count"`
+			} `json:"edge_media_preview_like"`
+			Comments struct {
+				Count int `json:"count"`
+			} `json:"edge_media_to_parent_comment"`
+			Sidecar struct {
+				Edges []struct {
+					Node rawMediaNode `json:"node"`
+				} `json:"edges"`
+			} `json:"edge_sidecar_to_children"`
+			MusicAttribution *rawMusicAttribution `json:"clips_music_attribution_info"`
+		} `json:"xdt_shortcode_media"`
+	} `json:"data"`
+	Status string `json:"status"`
+}
+
+// Scrapes post data using the same GraphQL query the instagram web client uses.
+// Doesn't require a cookie so it works as an independent fallback for when the
+// embed page stops containing the data we need
+func ScrapeFromGQL(ctx context.Context, postId string) (*HtmlData, error) {
+	variables, err := json.Marshal(map[string]any{
+		"shortcode":               postId,
+		"fetch_tagged_user_count": nil,
+		"hoisted_comment_id":      nil,
+		"hoisted_reply_id":        nil,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode GraphQL variables: %v", err)
+	}
+
+	form := url.Values{}
+	form.Set("variables", string(variables))
+	form.Set("doc_id", *flags.GraphQLDocID)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", gqlEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare HTTP request: %v", err)
+	}
+
+	appId := *flags.InstagramXIGAppID
+	if appId == "" {
+		appId = gqlWebAppID
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", *flags.InstagramBrowserAgent)
+	req.Header.Set("X-IG-App-ID", appId)
+	req.Header.Set("X-FB-Friendly-Name", "PolarisPostActionLoadPostQueryQuery")
+
+	// Set headers so we look more like a real browser
+	req.Header.Set("Sec-Fetch-Site", "same-origin")
+	req.Header.Set("Origin", "https://www.instagram.com")
+	req.Header.Set("Referer", "https://www.instagram.com/p/"+postId+"/")
+
+	slog.Debug("Sending GraphQL request", slog.String("id", postId))
+
+	res, err := GetIpRotationClient(5).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch data: status %d", res.StatusCode)
+	}
+
+	var gql gqlResponse
+	if err := json.NewDecoder(res.Body).Decode(&gql); err != nil {
+		return nil, fmt.Errorf("failed to decode GraphQL response: %v", err)
+	}
+
+	m := gql.Data.Media
+	// Instagram returns a null media for private, deleted and age-restricted posts
+	if m == nil {
+		return nil, nil
+	}
+
+	media := make([]MediaItem, 0, len(m.Sidecar.Edges)+1)
+
+	for _, edge := range m.Sidecar.Edges {
+		media = append(media, edge.Node.toMediaItem())
+	}
+
+	if len(media) == 0 {
+		media = append(media, m.toMediaItem())
+	}
+
+	caption := ""
+	if len(m.Caption.Edges) > 0 {
+		caption = m.Caption.Edges[0].Node.Text
+	}
+
+	var music *MusicData
+	if m.MusicAttribution != nil {
+		music = m.MusicAttribution.toMusicData()
+	}
+
+	views := m.VideoViewCount
+	if views == 0 {
+		views = m.VideoPlayCount
+	}
+
+	return &HtmlData{
+		Shortcode: m.Shortcode,
+		Author: &AuthorData{
+			Username:   m.Owner.Username,
+			ProfileURL: "https://www.instagram.com/" + m.Owner.Username,
+		},
+		Permalink:    "https://www.instagram.com/p/" + m.Shortcode + "/",
+		ThumbnailURL: m.ThumbnailURL,
+		IsVideo:      m.IsVideo,
+		Title:        caption,
+		Views:        views,
+		Likes:        m.Likes.Count,
+		Comments:     m.Comments.Count,
+		Video:        media[0].Video,
+		Media:        media,
+		Music:        music,
+	}, nil
+}
@@ -0,0 +1,257 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// Max amount of images that are put into a single mosaic
+	MosaicMaxImages = 4
+
+	mosaicCellWidth = 600
+	mosaicGap       = 4
+)
+
+// Returns the URLs of the carousel images that can be composed into a mosaic.
+// Video slides are skipped since their thumbnail isn't what the user wants to see
+func MosaicImages(data *HtmlData) []string {
+	urls := make([]string, 0, MosaicMaxImages)
+
+	for _, item := range data.Media {
+		if item.Video != nil || item.ThumbnailURL == "" {
+			continue
+		}
+
+		urls = append(urls, item.ThumbnailURL)
+
+		if len(urls) == MosaicMaxImages {
+			break
+		}
+	}
+
+	return urls
+}
+
+// Returns the path the mosaic for the given shortcode is stored at
+func MosaicPath(dir, shortcode string) string {
+	return filepath.Join(dir, shortcode+".jpg")
+}
+
+// Composes the carousel images of a post into a single JPEG and stores it in dir.
+// Returns the path to the mosaic. Already composed mosaics are not composed again
+func ComposeMosaic(dir string, data *HtmlData) (string, error) {
+	path := MosaicPath(dir, data.Shortcode)
+
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	urls := MosaicImages(data)
+	if len(urls) < 2 {
+		return "", fmt.Errorf("not enough images to compose a mosaic: %d", len(urls))
+	}
+
+	images := make([]image.Image, len(urls))
+	errs := make([]error, len(urls))
+
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			images[i], errs[i] = fetchImage(url)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return "", err
+		}
+	}
+
+	mosaic := composeGrid(images)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create mosaic directory: %v", err)
+	}
+
+	// Write to a temporary file first so concurrent requests never read a half written image
+	tmp, err := os.CreateTemp(dir, data.Shortcode+"-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary mosaic file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := jpeg.Encode(tmp, mosaic, &jpeg.Options{Quality: 85}); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to encode mosaic: %v", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to move mosaic into place: %v", err)
+	}
+
+	return path, nil
+}
+
+func fetchImage(url string) (image.Image, error) {
+	res, err := GetIpRotationClient(5).Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch image: status %d", res.StatusCode)
+	}
+
+	img, _, err := image.Decode(io.LimitReader(res.Body, 32*1024*1024))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	return img, nil
+}
+
+// Lays out 2 to 4 images in a grid:
+//
+//	2 images: side by side
+//	3 images: the first one on the left, the other two stacked on the right
+//	4 images: 2x2
+//
+// Cells take the aspect ratio of the first image
+func composeGrid(images []image.Image) *image.RGBA {
+	first := images[0].Bounds()
+
+	cellW := mosaicCellWidth
+	cellH := cellW * first.Dy() / max(first.Dx(), 1)
+	// Keep extremely tall or wide images from producing absurd mosaics
+	cellH = min(max(cellH, cellW/2), cellW*2)
+
+	var cells []image.Rectangle
+
+	switch len(images) {
+	case 2:
+		cells = []image.Rectangle{
+			image.Rect(0, 0, cellW, cellH),
+			image.Rect(cellW+mosaicGap, 0, 2*cellW+mosaicGap, cellH),
+		}
+	case 3:
+		cells = []image.Rectangle{
+			image.Rect(0, 0, cellW, 2*cellH+mosaicGap),
+			image.Rect(cellW+mosaicGap, 0, 2*cellW+mosaicGap, cellH),
+			image.Rect(cellW+mosaicGap, cellH+mosaicGap, 2*cellW+mosaicGap, 2*cellH+mosaicGap),
+		}
+	default:
+		cells = []image.Rectangle{
+			image.Rect(0, 0, cellW, cellH),
+			image.Rect(cellW+mosaicGap, 0, 2*cellW+mosaicGap, cellH),
+			image.Rect(0, cellH+mosaicGap, cellW, 2*cellH+mosaicGap),
+			image.Rect(cellW+mosaicGap, cellH+mosaicGap, 2*cellW+mosaicGap, 2*cellH+mosaicGap),
+		}
+	}
+
+	bounds := image.Rectangle{}
+	for _, cell := range cells {
+		bounds = bounds.Union(cell)
+	}
+
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, &image.Uniform{color.Black}, image.Point{}, draw.Src)
+
+	for i, cell := range cells {
+		drawCover(dst, cell, images[i])
+	}
+
+	return dst
+}
+
+// Scales src so it covers the whole cell (cropping the overflow around the
+// center) and draws it into dst using bilinear sampling
+func drawCover(dst *image.RGBA, cell image.Rectangle, src image.Image) {
+	sb := src.Bounds()
+	if sb.Empty() {
+		return
+	}
+
+	scale := max(float64(cell.Dx())/float64(sb.Dx()), float64(cell.Dy())/float64(sb.Dy()))
+
+	offX := (float64(sb.Dx())*scale - float64(cell.Dx())) / 2
+	offY := (float64(sb.Dy())*scale - float64(cell.Dy())) / 2
+
+	for y := cell.Min.Y; y < cell.Max.Y; y++ {
+		sy := (float64(y-cell.Min.Y)+offY+0.5)/scale - 0.5
+
+		for x := cell.Min.X; x < cell.Max.X; x++ {
+			sx := (float64(x-cell.Min.X)+offX+0.5)/scale - 0.5
+
+			dst.SetRGBA(x, y, bilinear(src, sb, sx, sy))
+		}
+	}
+}
+
+func bilinear(src image.Image, b image.Rectangle, x, y float64) color.RGBA {
+	x0 := clampInt(int(x), 0, b.Dx()-1)
+	y0 := clampInt(int(y), 0, b.Dy()-1)
+	x1 := clampInt(x0+1, 0, b.Dx()-1)
+	y1 := clampInt(y0+1, 0, b.Dy()-1)
+
+	fx := min(max(x-float64(x0), 0), 1)
+	fy := min(max(y-float64(y0), 0), 1)
+
+	r00, g00, b00, _ := src.At(b.Min.X+x0, b.Min.Y+y0).RGBA()
+	r10, g10, b10, _ := src.At(b.Min.X+x1, b.Min.Y+y0).RGBA()
+	r01, g01, b01, _ := src.At(b.Min.X+x0, b.Min.Y+y1).RGBA()
+	r11, g11, b11, _ := src.At(b.Min.X+x1, b.Min.Y+y1).RGBA()
+
+	lerp := func(c00, c10, c01, c11 uint32) uint8 {
+		top := float64(c00)*(1-fx) + float64(c10)*fx
+		bottom := float64(c01)*(1-fx) + float64(c11)*fx
+
+		return uint8((top*(1-fy) + bottom*fy) / 257)
+	}
+
+	return color.RGBA{
+		R: lerp(r00, r10, r01, r11),
+		G: lerp(g00, g10, g01, g11),
+		B: lerp(b00, b10, b01, b11),
+		A: 255,
+	}
+}
+
+func clampInt(v, lo, hi int) int {
+	return min(max(v, lo), hi)
+}
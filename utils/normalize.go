@@ -0,0 +1,128 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package utils
+
+import (
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Path segments instagram puts right before the shortcode
+	postKinds = []string{"p", "reel", "reels", "tv"}
+	// Query params added by the share sheet that don't change what's linked
+	trackingParams = []string{"igsh", "igshid", "utm_source", "utm_medium", "utm_campaign", "utm_content", "hl"}
+)
+
+// Pulls the shortcode and the requested carousel slide (0 if none) out of
+// any URL shape instagram uses:
+//
+//	/p/<id>, /reel/<id>, /reels/<id>, /tv/<id>, /reels/videos/<id>
+//	/<username>/p/<id>, /<username>/reel/<id>, /<username>/tv/<id>
+//
+// Works with full URLs (https://www.instagram.com/..., instagr.am/... or our
+// own domain) and plain paths. A trailing slide number (/p/<id>/2) or the
+// img_index query param select a slide
+//
+// Share links (/share/...) aren't posts and are rejected, see [ParseShareURL]
+func ParsePostURL(raw string) (shortcode string, slide int, ok bool) {
+	u, segments, ok := parseURL(raw)
+	if !ok || len(segments) > 0 && segments[0] == "share" {
+		return "", 0, false
+	}
+
+	// Usernames can't contain slashes so the post kind is either the first
+	// or the second segment
+	start := -1
+	switch {
+	case len(segments) >= 3 && segments[0] == "reels" && segments[1] == "videos":
+		start = 1
+	case len(segments) >= 2 && slices.Contains(postKinds, segments[0]):
+		start = 0
+	case len(segments) >= 3 && slices.Contains(postKinds, segments[1]):
+		start = 1
+	}
+
+	if start == -1 {
+		return "", 0, false
+	}
+
+	shortcode = segments[start+1]
+
+	if len(segments) > start+2 {
+		slide, _ = strconv.Atoi(segments[start+2])
+	}
+
+	if index, err := strconv.Atoi(u.Query().Get("img_index")); err == nil {
+		slide = index
+	}
+
+	return shortcode, max(slide, 0), shortcode != ""
+}
+
+// Pulls the kind (empty for old links) and ID out of share links generated by
+// the instagram app: /share/<id> and /share/<kind>/<id>. Works with full URLs too
+func ParseShareURL(raw string) (kind, shareId string, ok bool) {
+	_, segments, ok := parseURL(raw)
+	if !ok || len(segments) < 2 || segments[0] != "share" {
+		return "", "", false
+	}
+
+	if len(segments) == 2 {
+		return "", segments[1], true
+	}
+
+	return segments[1], segments[2], true
+}
+
+// Parses full URLs, URLs without a scheme and plain paths, returning the path segments
+func parseURL(raw string) (*url.URL, []string, bool) {
+	raw = strings.TrimSpace(raw)
+
+	// Without a scheme url.Parse treats the host as part of the path
+	if !strings.Contains(raw, "://") && !strings.HasPrefix(raw, "/") {
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	return u, strings.FieldsFunc(u.Path, func(r rune) bool { return r == '/' }), true
+}
+
+// Returns the request URI without the tracking params the instagram share
+// sheet adds, so links to the same post share a single cache entry
+func StripTrackingParams(requestURI string) string {
+	u, err := url.ParseRequestURI(requestURI)
+	if err != nil || u.RawQuery == "" {
+		return requestURI
+	}
+
+	query := u.Query()
+	for _, param := range trackingParams {
+		query.Del(param)
+	}
+
+	u.RawQuery = query.Encode()
+
+	return u.RequestURI()
+}
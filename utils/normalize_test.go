@@ -0,0 +1,94 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package utils
+
+import "testing"
+
+func TestParsePostURL(t *testing.T) {
+	tests := []struct {
+		raw       string
+		shortcode string
+		slide     int
+		ok        bool
+	}{
+		{"/p/DGnYkxfSDvL", "DGnYkxfSDvL", 0, true},
+		{"/p/DGnYkxfSDvL/2", "DGnYkxfSDvL", 2, true},
+		{"/reel/DGnYkxfSDvL/", "DGnYkxfSDvL", 0, true},
+		{"/reels/DGnYkxfSDvL", "DGnYkxfSDvL", 0, true},
+		{"/tv/DGnYkxfSDvL", "DGnYkxfSDvL", 0, true},
+		{"/reels/videos/DGnYkxfSDvL", "DGnYkxfSDvL", 0, true},
+		{"/someone/p/DGnYkxfSDvL", "DGnYkxfSDvL", 0, true},
+		{"/someone/reel/DGnYkxfSDvL", "DGnYkxfSDvL", 0, true},
+		{"/someone/tv/DGnYkxfSDvL", "DGnYkxfSDvL", 0, true},
+		{"https://www.instagram.com/p/DGnYkxfSDvL/?img_index=3", "DGnYkxfSDvL", 3, true},
+		{"https://www.instagram.com/reel/DGnYkxfSDvL/?igsh=MWQ1ZGUxMzBkMA==", "DGnYkxfSDvL", 0, true},
+		{"instagr.am/p/DGnYkxfSDvL", "DGnYkxfSDvL", 0, true},
+		{"https://instagr.am/reel/DGnYkxfSDvL/", "DGnYkxfSDvL", 0, true},
+		{"/share/reel/BAB1Nc2Yp7q", "", 0, false},
+		{"/share/BAB1Nc2Yp7q", "", 0, false},
+		{"https://www.instagram.com/share/p/BAB1Nc2Yp7q/", "", 0, false},
+		{"/", "", 0, false},
+		{"/someone", "", 0, false},
+		{"/explore/tags/cats", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		shortcode, slide, ok := ParsePostURL(tt.raw)
+		if shortcode != tt.shortcode || slide != tt.slide || ok != tt.ok {
+			t.Errorf("ParsePostURL(%q) = %q, %d, %v; want %q, %d, %v", tt.raw, shortcode, slide, ok, tt.shortcode, tt.slide, tt.ok)
+		}
+	}
+}
+
+func TestParseShareURL(t *testing.T) {
+	tests := []struct {
+		raw     string
+		kind    string
+		shareId string
+		ok      bool
+	}{
+		{"/share/reel/BAB1Nc2Yp7q", "reel", "BAB1Nc2Yp7q", true},
+		{"/share/BAB1Nc2Yp7q/", "", "BAB1Nc2Yp7q", true},
+		{"https://www.instagram.com/share/p/BAB1Nc2Yp7q/?igsh=MWQ1ZGUxMzBkMA==", "p", "BAB1Nc2Yp7q", true},
+		{"instagram.com/share/reel/BAB1Nc2Yp7q", "reel", "BAB1Nc2Yp7q", true},
+		{"/share", "", "", false},
+		{"/p/DGnYkxfSDvL", "", "", false},
+		{"/someone/share/BAB1Nc2Yp7q", "", "", false},
+	}
+
+	for _, tt := range tests {
+		kind, shareId, ok := ParseShareURL(tt.raw)
+		if kind != tt.kind || shareId != tt.shareId || ok != tt.ok {
+			t.Errorf("ParseShareURL(%q) = %q, %q, %v; want %q, %q, %v", tt.raw, kind, shareId, ok, tt.kind, tt.shareId, tt.ok)
+		}
+	}
+}
+
+func TestStripTrackingParams(t *testing.T) {
+	tests := map[string]string{
+		"/p/DGnYkxfSDvL":                          "/p/DGnYkxfSDvL",
+		"/p/DGnYkxfSDvL?igsh=MWQ1ZGUxMzBkMA==":    "/p/DGnYkxfSDvL",
+		"/p/DGnYkxfSDvL?img_index=2&utm_source=x": "/p/DGnYkxfSDvL?img_index=2",
+	}
+
+	for raw, want := range tests {
+		if got := StripTrackingParams(raw); got != want {
+			t.Errorf("StripTrackingParams(%q) = %q; want %q", raw, got, want)
+		}
+	}
+}
@@ -20,17 +20,18 @@ package utils
 import (
 	"bitwise7/vxinst/flags"
 	"bufio"
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"time"
 )
 
-func ScrapeFromHTML(postId string) (*HtmlData, error) {
+func ScrapeFromHTML(ctx context.Context, postId string) (*HtmlData, error) {
 	origin := "https://instagram.com/p/" + postId + "/embed/captioned"
 
 	slog.Debug("Preparing request", slog.String("origin", origin))
-	req, err := http.NewRequest("GET", origin, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", origin, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare HTTP request: %v", err)
 	}
@@ -0,0 +1,119 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package utils
+
+import (
+	"bitwise7/vxinst/flags"
+	"bitwise7/vxinst/shortcode"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const maxShareRedirects = 5
+
+var (
+	// Returned when a share link doesn't lead to a post
+	ErrNotAPost = errors.New("share link doesn't point at a post")
+
+	shareClient = &http.Client{
+		Timeout: 5 * time.Second,
+		// Links to posts redirect once or twice within instagram. Anything
+		// leaving instagram or redirecting over and over isn't a post
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxShareRedirects {
+				return fmt.Errorf("%w: stopped after %d redirects", ErrNotAPost, maxShareRedirects)
+			}
+
+			if !IsInstagramHost(req.URL.Hostname()) {
+				return fmt.Errorf("%w: refusing to follow redirect to %s", ErrNotAPost, req.URL.Hostname())
+			}
+
+			return nil
+		},
+	}
+)
+
+// Maps the share ID the instagram app generates to the shortcode of the post
+type ShareLink struct {
+	ID        string `gorm:"primaryKey"`
+	Shortcode string
+	ExpiresAt int64
+}
+
+func IsInstagramHost(host string) bool {
+	host = strings.ToLower(host)
+	return host == "instagram.com" || strings.HasSuffix(host, ".instagram.com")
+}
+
+// Follows the redirects of a share link (https://www.instagram.com/share/<kind>/<id>)
+// and returns the shortcode of the post it points at. kind may be empty
+func ResolveShare(ctx context.Context, kind, shareId string) (string, error) {
+	if kind != "" && !slices.Contains(postKinds, kind) || !shortcode.Valid(shareId) {
+		return "", ErrNotAPost
+	}
+
+	path := "/share/"
+	if kind != "" {
+		path += url.PathEscape(kind) + "/"
+	}
+	path += url.PathEscape(shareId) + "/"
+
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://www.instagram.com"+path, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare request to follow redirects: %v", err)
+	}
+
+	req.Header.Set("User-Agent", *flags.InstagramBrowserAgent)
+
+	res, err := shareClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to follow redirects: %w", err)
+	}
+	res.Body.Close()
+
+	final := res.Request.URL
+
+	if !IsInstagramHost(final.Hostname()) {
+		return "", ErrNotAPost
+	}
+
+	// Logged out clients get sent to the login page with the post in ?next=
+	if strings.HasPrefix(final.Path, "/accounts/login") {
+		if next, err := url.Parse(final.Query().Get("next")); err == nil {
+			final = next
+		}
+	}
+
+	postId, _, ok := ParsePostURL(final.Path)
+	if !ok {
+		return "", ErrNotAPost
+	}
+
+	postId, ok = shortcode.Parse(postId)
+	if !ok {
+		return "", ErrNotAPost
+	}
+
+	return postId, nil
+}
@@ -0,0 +1,53 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestShareRedirects(t *testing.T) {
+	req := func(url string) *http.Request {
+		r, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	first := []*http.Request{req("https://www.instagram.com/share/reel/BAB1Nc2Yp7q/")}
+
+	if err := shareClient.CheckRedirect(req("https://www.instagram.com/reel/DGnYkxfSDvL/"), first); err != nil {
+		t.Errorf("redirect within instagram refused: %v", err)
+	}
+
+	if err := shareClient.CheckRedirect(req("https://example.com/"), first); !errors.Is(err, ErrNotAPost) {
+		t.Errorf("redirect away from instagram = %v; want %v", err, ErrNotAPost)
+	}
+
+	loop := make([]*http.Request, maxShareRedirects)
+	for i := range loop {
+		loop[i] = first[0]
+	}
+
+	if err := shareClient.CheckRedirect(req("https://www.instagram.com/share/reel/BAB1Nc2Yp7q/"), loop); !errors.Is(err, ErrNotAPost) {
+		t.Errorf("redirect loop = %v; want %v", err, ErrNotAPost)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+vxinst - Blazing fast embedder for instagram posts
+Copyright (C) 2025 Bash06
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package utils
+
+import (
+	"bitwise7/vxinst/flags"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	secretsOnce  sync.Once
+	mediaSecrets [][]byte
+)
+
+// Returns the secrets media links are signed with. The first one signs new
+// links, the rest are only used to verify links signed before a rotation
+func signingSecrets() [][]byte {
+	secretsOnce.Do(func() {
+		for _, secret := range *flags.MediaSecrets {
+			if secret != "" {
+				mediaSecrets = append(mediaSecrets, []byte(secret))
+			}
+		}
+
+		if len(mediaSecrets) == 0 {
+			slog.Warn("No media secret provided. Using a random one, media links will stop working after a restart")
+
+			secret := make([]byte, 32)
+			rand.Read(secret)
+
+			mediaSecrets = [][]byte{secret}
+		}
+	})
+
+	return mediaSecrets
+}
+
+func mediaSignature(secret []byte, shortcode string, index int, thumbnail bool, expires int64) string {
+	mac := hmac.New(sha256.New, secret)
+
+	mac.Write([]byte(shortcode))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.Itoa(index)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatBool(thumbnail)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(expires, 10)))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Signs a link to the media of a post slide. Returns the expiry time and
+// signature that have to be passed back to [VerifyMediaLink]
+func SignMediaLink(shortcode string, index int, thumbnail bool) (int64, string) {
+	expires := time.Now().Add(time.Hour * time.Duration(*flags.MediaLinkLifetime)).Unix()
+
+	return expires, mediaSignature(signingSecrets()[0], shortcode, index, thumbnail, expires)
+}
+
+// Reports if the signature was made by us (with the current or any previous
+// secret) for this exact media and if the link is still valid
+func VerifyMediaLink(shortcode string, index int, thumbnail bool, expires int64, signature string) bool {
+	if time.Now().Unix() > expires {
+		return false
+	}
+
+	for _, secret := range signingSecrets() {
+		expected := mediaSignature(secret, shortcode, index, thumbnail, expires)
+
+		if hmac.Equal([]byte(expected), []byte(signature)) {
+			return true
+		}
+	}
+
+	return false
+}